@@ -0,0 +1,226 @@
+package main
+
+import (
+	"chirpy/internal/auth"
+	"chirpy/internal/authz"
+	"chirpy/internal/database"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type contextKey int
+
+const subjectContextKey contextKey = iota
+
+// middlewareAuthenticate lets only authenticated callers through to next,
+// which finds the caller with authenticatedSubject. Callers are held to
+// their plan's rate limit.
+func (apiCfg *apiConfig) middlewareAuthenticate(next http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		subject, ok := apiCfg.authenticate(w, r)
+		if !ok {
+			return
+		}
+		if !apiCfg.rateLimit(w, r, subject) {
+			return
+		}
+		next(w, r.WithContext(context.WithValue(r.Context(), subjectContextKey, subject)))
+	})
+}
+
+// authenticatedSubject returns the caller middlewareAuthenticate let in. A
+// handler mounted without the middleware gets the zero Subject, which has
+// no scopes and is denied everything.
+func authenticatedSubject(r *http.Request) authz.Subject {
+	subject, _ := r.Context().Value(subjectContextKey).(authz.Subject)
+	return subject
+}
+
+// authenticate validates the credential on r and loads the caller's role.
+// A bearer token is either a JWT access token or a personal access token.
+// Without an Authorization header, the access token cookie of a browser
+// session is used instead, and requests other than GET, HEAD and OPTIONS
+// must carry its CSRF token. On failure it writes a 401 or 403 and returns
+// false.
+func (apiCfg *apiConfig) authenticate(w http.ResponseWriter, r *http.Request) (authz.Subject, bool) {
+	if r.Header.Get("Authorization") == "" {
+		if cookie, err := r.Cookie(accessTokenCookie); err == nil {
+			return apiCfg.authenticateCookieSession(w, r, cookie.Value)
+		}
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Authorization token is missing or invalid")
+		return authz.Subject{}, false
+	}
+
+	if auth.IsPersonalAccessToken(token) {
+		return apiCfg.authenticatePersonalAccessToken(w, r, token)
+	}
+
+	claims, err := auth.ParseJWT(token, apiCfg.Keys)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Invalid or expired token")
+		return authz.Subject{}, false
+	}
+
+	return apiCfg.authenticateClaims(w, r, claims)
+}
+
+func (apiCfg *apiConfig) authenticateCookieSession(w http.ResponseWriter, r *http.Request, token string) (authz.Subject, bool) {
+	claims, err := auth.ParseJWT(token, apiCfg.Keys)
+	// Only tokens issued into a cookie carry a CSRF hash; a bearer token
+	// planted in the cookie is not accepted.
+	if err != nil || claims.CSRFHash == "" {
+		respondWithError(w, http.StatusUnauthorized, "Invalid or expired session")
+		return authz.Subject{}, false
+	}
+
+	if !isSafeMethod(r.Method) && !auth.CheckCSRFToken(claims, r.Header.Get(csrfTokenHeader)) {
+		respondWithError(w, http.StatusForbidden, errInvalidCSRFToken.Error())
+		return authz.Subject{}, false
+	}
+
+	return apiCfg.authenticateClaims(w, r, claims)
+}
+
+func (apiCfg *apiConfig) authenticateClaims(w http.ResponseWriter, r *http.Request, claims *auth.Claims) (authz.Subject, bool) {
+	userId, err := uuid.Parse(claims.Subject)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Invalid or expired token")
+		return authz.Subject{}, false
+	}
+
+	user, err := apiCfg.DB.GetUserFromId(r.Context(), userId)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Invalid or expired token")
+		return authz.Subject{}, false
+	}
+
+	subject := databaseUserToSubject(user)
+	subject.SessionID = auth.SessionIDFromClaims(claims)
+	subject.Scopes = auth.ParseScope(claims.Scope)
+	if claims.ExpiresAt != nil {
+		subject.ExpiresAt = claims.ExpiresAt.Time
+	}
+	return subject, true
+}
+
+func (apiCfg *apiConfig) authenticatePersonalAccessToken(w http.ResponseWriter, r *http.Request, token string) (authz.Subject, bool) {
+	pat, err := apiCfg.DB.GetPersonalAccessToken(r.Context(), auth.HashToken(token))
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Invalid or expired token")
+		return authz.Subject{}, false
+	}
+
+	user, err := apiCfg.DB.GetUserFromId(r.Context(), pat.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Invalid or expired token")
+		return authz.Subject{}, false
+	}
+
+	if err := apiCfg.DB.TouchPersonalAccessToken(r.Context(), pat.ID); err != nil {
+		log.Printf("Error updating last use of token %s: %s", pat.ID, err)
+	}
+
+	subject := databaseUserToSubject(user)
+	subject.TokenID = pat.ID
+	subject.ExpiresAt = pat.ExpiresAt.Time
+	// Tokens created before the account scope stopped being grantable may
+	// still carry it; it is ignored.
+	subject.Scopes = slices.DeleteFunc(auth.ParseScope(pat.Scope), func(scope string) bool {
+		return !slices.Contains(auth.GrantableScopes, scope)
+	})
+	return subject, true
+}
+
+// credentialActive reports whether the session or personal access token
+// subject authenticated with is still in force, for connections that
+// outlive the request that checked it.
+func (apiCfg *apiConfig) credentialActive(ctx context.Context, subject authz.Subject) (bool, error) {
+	if !subject.ExpiresAt.IsZero() && !time.Now().Before(subject.ExpiresAt) {
+		return false, nil
+	}
+	if subject.SessionID != uuid.Nil {
+		return apiCfg.DB.IsSessionActive(ctx, subject.SessionID)
+	}
+	if subject.TokenID != uuid.Nil {
+		return apiCfg.DB.IsPersonalAccessTokenActive(ctx, subject.TokenID)
+	}
+	return true, nil
+}
+
+// requireScope checks that the caller's credential was granted scope, for
+// handlers that do not go through authorize. On failure it writes a 403 and
+// returns false.
+func requireScope(w http.ResponseWriter, subject authz.Subject, scope string) bool {
+	if !subject.HasScope(scope) {
+		respondWithError(w, http.StatusForbidden, fmt.Sprintf("This token does not have the %s scope", scope))
+		return false
+	}
+	return true
+}
+
+// authorize asks the policy in internal/authz whether subject may act on
+// resource. On denial it writes a 403 and returns false.
+func authorize(w http.ResponseWriter, subject authz.Subject, action authz.Action, resource authz.Resource) bool {
+	if err := authz.Authorize(subject, action, resource); err != nil {
+		respondWithError(w, http.StatusForbidden, err.Error())
+		return false
+	}
+	return true
+}
+
+func databaseUserToSubject(dbUser database.User) authz.Subject {
+	return authz.Subject{UserID: dbUser.ID, Role: authz.Role(dbUser.Role), Verified: dbUser.Verified}
+}
+
+func (apiCfg *apiConfig) handlerUpdateUserRole(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Role string `json:"role"`
+	}
+
+	userID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	subject := authenticatedSubject(r)
+
+	if !authorize(w, subject, authz.ActionManageRoles, authz.Resource{Kind: authz.ResourceUser, OwnerID: userID}) {
+		return
+	}
+
+	params := parameters{}
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+
+	role, err := authz.ParseRole(params.Role)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	user, err := apiCfg.DB.UpdateUserRole(r.Context(), database.UpdateUserRoleParams{
+		ID:   userID,
+		Role: string(role),
+	})
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "User not found")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, apiCfg.userResponse(r.Context(), user))
+}
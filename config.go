@@ -0,0 +1,192 @@
+package main
+
+import (
+	"chirpy/internal/auth"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+// tokenConfig controls how long the tokens Chirpy hands out stay valid.
+type tokenConfig struct {
+	// AccessTTL is the access token lifetime when the client does not ask
+	// for one, and MaxAccessTTL caps what it may ask for.
+	AccessTTL    time.Duration
+	MaxAccessTTL time.Duration
+	RefreshTTL   time.Duration
+}
+
+func tokenConfigFromEnv() (tokenConfig, error) {
+	cfg := tokenConfig{}
+	var err error
+
+	if cfg.AccessTTL, err = durationFromEnv("ACCESS_TOKEN_TTL", auth.DefaultAccessTokenLifetime); err != nil {
+		return tokenConfig{}, err
+	}
+	if cfg.MaxAccessTTL, err = durationFromEnv("ACCESS_TOKEN_MAX_TTL", cfg.AccessTTL); err != nil {
+		return tokenConfig{}, err
+	}
+	if cfg.RefreshTTL, err = durationFromEnv("REFRESH_TOKEN_TTL", time.Hour*24*60); err != nil {
+		return tokenConfig{}, err
+	}
+
+	if cfg.AccessTTL <= 0 || cfg.RefreshTTL <= 0 {
+		return tokenConfig{}, fmt.Errorf("token lifetimes must be positive")
+	}
+	if cfg.MaxAccessTTL < cfg.AccessTTL {
+		return tokenConfig{}, fmt.Errorf("ACCESS_TOKEN_MAX_TTL must be at least ACCESS_TOKEN_TTL")
+	}
+
+	return cfg, nil
+}
+
+// accessTTL returns the lifetime for an access token the client asked to
+// last expiresInSeconds, falling back to the default when it did not ask
+// and never exceeding the server maximum.
+func (cfg tokenConfig) accessTTL(expiresInSeconds int) time.Duration {
+	if expiresInSeconds <= 0 {
+		return cfg.AccessTTL
+	}
+	return min(time.Duration(expiresInSeconds)*time.Second, cfg.MaxAccessTTL)
+}
+
+// passwordConfig controls how passwords are hashed and which new passwords
+// are accepted.
+type passwordConfig struct {
+	Argon2 auth.Argon2Params
+	Policy *auth.PasswordPolicy
+	// DummyHash is checked against when a login names an unknown email, so
+	// the answer takes as long as one for a wrong password.
+	DummyHash string
+}
+
+func passwordConfigFromEnv() (passwordConfig, error) {
+	cfg := passwordConfig{Argon2: auth.DefaultArgon2Params}
+
+	memory, err := intFromEnv("ARGON2_MEMORY_KIB", int(cfg.Argon2.Memory))
+	if err != nil {
+		return passwordConfig{}, err
+	}
+	iterations, err := intFromEnv("ARGON2_ITERATIONS", int(cfg.Argon2.Iterations))
+	if err != nil {
+		return passwordConfig{}, err
+	}
+	parallelism, err := intFromEnv("ARGON2_PARALLELISM", int(cfg.Argon2.Parallelism))
+	if err != nil {
+		return passwordConfig{}, err
+	}
+	if memory < 8*parallelism || iterations < 1 || parallelism < 1 || parallelism > 255 {
+		return passwordConfig{}, fmt.Errorf("invalid Argon2 parameters m=%d t=%d p=%d", memory, iterations, parallelism)
+	}
+	cfg.Argon2.Memory = uint32(memory)
+	cfg.Argon2.Iterations = uint32(iterations)
+	cfg.Argon2.Parallelism = uint8(parallelism)
+
+	minLength, err := intFromEnv("PASSWORD_MIN_LENGTH", 8)
+	if err != nil {
+		return passwordConfig{}, err
+	}
+	cfg.Policy = auth.NewPasswordPolicy(minLength)
+	if path := os.Getenv("BREACHED_PASSWORDS_FILE"); path != "" {
+		if err := cfg.Policy.LoadBreachedPasswords(path); err != nil {
+			return passwordConfig{}, err
+		}
+	}
+
+	if cfg.DummyHash, err = auth.HashPassword("chirpy-dummy-password", cfg.Argon2); err != nil {
+		return passwordConfig{}, err
+	}
+
+	return cfg, nil
+}
+
+func (cfg passwordConfig) hash(password string) (string, error) {
+	return auth.HashPassword(password, cfg.Argon2)
+}
+
+// polkaConfig controls how Polka webhook deliveries are authenticated.
+// Mode is "signature" to require an HMAC signature, "apikey" for the legacy
+// static key, or "both" to accept either while Polka moves over.
+type polkaConfig struct {
+	Mode          string
+	APIKey        string
+	SigningSecret string
+	// Tolerance is how far a signature's timestamp may be from now.
+	Tolerance time.Duration
+}
+
+// polkaConfigFromEnv reads the Polka settings. Without POLKA_AUTH_MODE,
+// deployments that only have POLKA_KEY keep using it, and those that have
+// added POLKA_WEBHOOK_SECRET to it accept both. Requiring signatures is
+// opt-in.
+func polkaConfigFromEnv() (polkaConfig, error) {
+	cfg := polkaConfig{
+		APIKey:        os.Getenv("POLKA_KEY"),
+		SigningSecret: os.Getenv("POLKA_WEBHOOK_SECRET"),
+	}
+	defaultMode := "apikey"
+	if cfg.SigningSecret != "" && cfg.APIKey != "" {
+		defaultMode = "both"
+	}
+	cfg.Mode = stringFromEnv("POLKA_AUTH_MODE", defaultMode)
+	var err error
+	if cfg.Tolerance, err = durationFromEnv("POLKA_SIGNATURE_TOLERANCE", time.Minute*5); err != nil {
+		return polkaConfig{}, err
+	}
+
+	switch cfg.Mode {
+	case "signature", "apikey", "both":
+	default:
+		return polkaConfig{}, fmt.Errorf("POLKA_AUTH_MODE must be signature, apikey or both")
+	}
+	if cfg.acceptsSignature() && cfg.SigningSecret == "" {
+		return polkaConfig{}, fmt.Errorf("POLKA_WEBHOOK_SECRET is required when POLKA_AUTH_MODE is %s", cfg.Mode)
+	}
+	if cfg.acceptsAPIKey() && cfg.APIKey == "" {
+		return polkaConfig{}, fmt.Errorf("POLKA_KEY is required when POLKA_AUTH_MODE is %s; set POLKA_AUTH_MODE=signature to accept only signed deliveries", cfg.Mode)
+	}
+
+	return cfg, nil
+}
+
+func (cfg polkaConfig) acceptsSignature() bool {
+	return cfg.Mode == "signature" || cfg.Mode == "both"
+}
+
+func (cfg polkaConfig) acceptsAPIKey() bool {
+	return cfg.Mode == "apikey" || cfg.Mode == "both"
+}
+
+func intFromEnv(name string, fallback int) (int, error) {
+	raw := os.Getenv(name)
+	if raw == "" {
+		return fallback, nil
+	}
+
+	n, err := strconv.Atoi(raw)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", name, err)
+	}
+	return n, nil
+}
+
+func durationFromEnv(name string, fallback time.Duration) (time.Duration, error) {
+	raw := os.Getenv(name)
+	if raw == "" {
+		return fallback, nil
+	}
+
+	d, err := time.ParseDuration(raw)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", name, err)
+	}
+	return d, nil
+}
+
+func stringFromEnv(name, fallback string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return fallback
+}
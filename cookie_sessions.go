@@ -0,0 +1,97 @@
+package main
+
+import (
+	"chirpy/internal/auth"
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Browser sessions keep their tokens in HttpOnly cookies that scripts on
+// the page cannot read. The CSRF token is the exception: the frontend reads
+// it from its cookie and echoes it in the X-CSRF-Token header on every
+// state-changing request. The access token carries a hash of it, so a
+// forged request has to know a value only pages on our origin can read.
+const (
+	accessTokenCookie  = "chirpy_access"
+	refreshTokenCookie = "chirpy_refresh"
+	csrfTokenCookie    = "chirpy_csrf"
+	csrfTokenHeader    = "X-CSRF-Token"
+)
+
+var errInvalidCSRFToken = errors.New("CSRF token is missing or invalid")
+
+// wantsCookieSession reports whether a login asked for a cookie session
+// rather than tokens in the response body, with ?session=cookie.
+func wantsCookieSession(r *http.Request) bool {
+	return r.URL.Query().Get("session") == "cookie"
+}
+
+func isSafeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+// sessionCookie is only marked Secure when Chirpy is served over https, so
+// cookie sessions also work in local development over plain http.
+func (apiCfg *apiConfig) sessionCookie(name, value, path string, maxAge time.Duration, httpOnly bool) *http.Cookie {
+	return &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     path,
+		MaxAge:   int(maxAge.Seconds()),
+		HttpOnly: httpOnly,
+		Secure:   strings.HasPrefix(apiCfg.BaseURL, "https://"),
+		SameSite: http.SameSiteStrictMode,
+	}
+}
+
+// setSessionCookies signs a cookie access token for the session and sets it
+// together with the refresh token and a new CSRF token.
+func (apiCfg *apiConfig) setSessionCookies(w http.ResponseWriter, userID, sessionID uuid.UUID, scopes []string, refreshToken string, accessTTL time.Duration) error {
+	csrfToken, err := auth.MakeRandomToken()
+	if err != nil {
+		return err
+	}
+
+	accessToken, err := auth.MakeCookieJWT(userID, apiCfg.Keys, sessionID, accessTTL, scopes, csrfToken)
+	if err != nil {
+		return err
+	}
+
+	http.SetCookie(w, apiCfg.sessionCookie(accessTokenCookie, accessToken, "/", accessTTL, true))
+	http.SetCookie(w, apiCfg.sessionCookie(refreshTokenCookie, refreshToken, "/api", apiCfg.Tokens.RefreshTTL, true))
+	// The CSRF token lives as long as the refresh token, since refreshing
+	// needs it too.
+	http.SetCookie(w, apiCfg.sessionCookie(csrfTokenCookie, csrfToken, "/", apiCfg.Tokens.RefreshTTL, false))
+	return nil
+}
+
+func (apiCfg *apiConfig) clearSessionCookies(w http.ResponseWriter) {
+	http.SetCookie(w, apiCfg.sessionCookie(accessTokenCookie, "", "/", -time.Second, true))
+	http.SetCookie(w, apiCfg.sessionCookie(refreshTokenCookie, "", "/api", -time.Second, true))
+	http.SetCookie(w, apiCfg.sessionCookie(csrfTokenCookie, "", "/", -time.Second, false))
+}
+
+// refreshTokenFromCookie returns the refresh token of a cookie session. The
+// CSRF header has to match the CSRF cookie; the access token, which the
+// header is normally checked against, may have expired by now.
+func refreshTokenFromCookie(r *http.Request) (string, error) {
+	refreshCookie, err := r.Cookie(refreshTokenCookie)
+	if err != nil {
+		return "", err
+	}
+	csrfCookie, err := r.Cookie(csrfTokenCookie)
+	if err != nil {
+		return "", errInvalidCSRFToken
+	}
+
+	header := r.Header.Get(csrfTokenHeader)
+	if header == "" || subtle.ConstantTimeCompare([]byte(header), []byte(csrfCookie.Value)) != 1 {
+		return "", errInvalidCSRFToken
+	}
+	return refreshCookie.Value, nil
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"chirpy/internal/authz"
+	"chirpy/internal/database"
+	"chirpy/internal/entitlements"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	chirpQuotaPeriod = time.Hour * 24
+	// maxMediaURLLength keeps a chirp with a few attachments well within
+	// what fits in one event on the Postgres event bus.
+	maxMediaURLLength = 1024
+)
+
+var (
+	errChirpQuotaReached   = errors.New("You have reached your daily chirp limit")
+	errRateLimited         = errors.New("Too many requests, slow down")
+	errChirpEditingNotPaid = errors.New("Editing chirps needs Chirpy Red")
+)
+
+// loadPlan is the entitlements Engine's Loader.
+func (apiCfg *apiConfig) loadPlan(ctx context.Context, name string) (entitlements.Entitlements, error) {
+	plan, err := apiCfg.DB.GetPlan(ctx, name)
+	if errors.Is(err, sql.ErrNoRows) {
+		return entitlements.Entitlements{}, entitlements.ErrUnknownPlan
+	}
+	if err != nil {
+		return entitlements.Entitlements{}, err
+	}
+	return databasePlanToEntitlements(plan), nil
+}
+
+// entitlementsFor returns what the user's plan allows: the plan of their
+// subscription while it is active, and the free plan otherwise.
+func (apiCfg *apiConfig) entitlementsFor(ctx context.Context, userID uuid.UUID) (entitlements.Entitlements, error) {
+	subscription, active, err := apiCfg.activeSubscription(ctx, userID)
+	if err != nil {
+		return entitlements.Entitlements{}, err
+	}
+
+	plan := entitlements.FreePlan
+	if active {
+		plan = subscription.Plan
+	}
+	return apiCfg.Entitlements.ForPlan(ctx, plan)
+}
+
+// rateLimit counts the request against the caller's per-minute allowance.
+// When it runs out it writes a 429 and returns false. Callers are let
+// through if their plan can't be loaded.
+func (apiCfg *apiConfig) rateLimit(w http.ResponseWriter, r *http.Request, subject authz.Subject) bool {
+	limits, err := apiCfg.entitlementsFor(r.Context(), subject.UserID)
+	if err != nil {
+		log.Printf("Error loading entitlements for user %s: %s", subject.UserID, err)
+		return true
+	}
+	if limits.RequestsPerMinute <= 0 {
+		return true
+	}
+
+	decision := apiCfg.RateLimiter.Allow(subject.UserID.String(), limits.RequestsPerMinute, time.Now())
+	w.Header().Set("X-RateLimit-Limit", strconv.Itoa(limits.RequestsPerMinute))
+	w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(decision.Remaining))
+	if !decision.Allowed {
+		w.Header().Set("Retry-After", retryAfterSeconds(decision.RetryAfter))
+		respondWithError(w, http.StatusTooManyRequests, errRateLimited.Error())
+		return false
+	}
+	return true
+}
+
+// checkChirpQuota reports whether the user may post another chirp today.
+// The day is the last 24 hours rather than a calendar day. qtx must be in
+// the transaction that creates the chirp: it holds a lock on the user until
+// then, so posts made at the same time can't all fit in the last slot.
+func checkChirpQuota(ctx context.Context, qtx *database.Queries, userID uuid.UUID, limits entitlements.Entitlements) (bool, error) {
+	if limits.DailyChirpQuota <= 0 {
+		return true, nil
+	}
+
+	if err := qtx.LockUserChirps(ctx, userID); err != nil {
+		return false, err
+	}
+	count, err := qtx.CountChirpsSince(ctx, database.CountChirpsSinceParams{
+		UserID:    userID,
+		CreatedAt: time.Now().Add(-chirpQuotaPeriod),
+	})
+	if err != nil {
+		return false, err
+	}
+	return count < int64(limits.DailyChirpQuota), nil
+}
+
+func validateMediaURLs(mediaURLs []string, limits entitlements.Entitlements) error {
+	if len(mediaURLs) > limits.MaxMediaAttachments {
+		if limits.MaxMediaAttachments == 0 {
+			return errors.New("Your plan does not include media attachments")
+		}
+		return fmt.Errorf("A chirp can have at most %d media attachments", limits.MaxMediaAttachments)
+	}
+	for _, mediaURL := range mediaURLs {
+		if len(mediaURL) > maxMediaURLLength {
+			return fmt.Errorf("media_urls can be at most %d characters long", maxMediaURLLength)
+		}
+		u, err := url.Parse(mediaURL)
+		if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			return errors.New("media_urls must be http or https URLs")
+		}
+	}
+	return nil
+}
+
+type Plan struct {
+	Name                string    `json:"name"`
+	CreatedAt           time.Time `json:"created_at"`
+	UpdatedAt           time.Time `json:"updated_at"`
+	MaxChirpLength      int32     `json:"max_chirp_length"`
+	CanEditChirps       bool      `json:"can_edit_chirps"`
+	DailyChirpQuota     int32     `json:"daily_chirp_quota"`
+	MaxMediaAttachments int32     `json:"max_media_attachments"`
+	RequestsPerMinute   int32     `json:"requests_per_minute"`
+}
+
+type Entitlements struct {
+	Plan                string `json:"plan"`
+	MaxChirpLength      int    `json:"max_chirp_length"`
+	CanEditChirps       bool   `json:"can_edit_chirps"`
+	DailyChirpQuota     int    `json:"daily_chirp_quota"`
+	MaxMediaAttachments int    `json:"max_media_attachments"`
+	RequestsPerMinute   int    `json:"requests_per_minute"`
+}
+
+func databasePlanToPlan(plan database.Plan) Plan {
+	return Plan{
+		Name:                plan.Name,
+		CreatedAt:           plan.CreatedAt,
+		UpdatedAt:           plan.UpdatedAt,
+		MaxChirpLength:      plan.MaxChirpLength,
+		CanEditChirps:       plan.CanEditChirps,
+		DailyChirpQuota:     plan.DailyChirpQuota,
+		MaxMediaAttachments: plan.MaxMediaAttachments,
+		RequestsPerMinute:   plan.RequestsPerMinute,
+	}
+}
+
+func databasePlanToEntitlements(plan database.Plan) entitlements.Entitlements {
+	return entitlements.Entitlements{
+		Plan:                plan.Name,
+		MaxChirpLength:      int(plan.MaxChirpLength),
+		CanEditChirps:       plan.CanEditChirps,
+		DailyChirpQuota:     int(plan.DailyChirpQuota),
+		MaxMediaAttachments: int(plan.MaxMediaAttachments),
+		RequestsPerMinute:   int(plan.RequestsPerMinute),
+	}
+}
+
+// handlerGetEntitlements shows the caller what their plan allows.
+func (apiCfg *apiConfig) handlerGetEntitlements(w http.ResponseWriter, r *http.Request) {
+	subject := authenticatedSubject(r)
+
+	limits, err := apiCfg.entitlementsFor(r.Context(), subject.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Could not load entitlements")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, Entitlements(limits))
+}
+
+func (apiCfg *apiConfig) handlerListPlans(w http.ResponseWriter, r *http.Request) {
+	subject := authenticatedSubject(r)
+	if !authorize(w, subject, authz.ActionEdit, authz.Resource{Kind: authz.ResourcePlan}) {
+		return
+	}
+
+	plans, err := apiCfg.DB.ListPlans(r.Context())
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Could not load plans")
+		return
+	}
+
+	response := []Plan{}
+	for _, plan := range plans {
+		response = append(response, databasePlanToPlan(plan))
+	}
+	respondWithJSON(w, http.StatusOK, response)
+}
+
+// handlerUpdatePlan creates or replaces a plan definition. Other instances
+// drop their cached copy when the change reaches them on the event bus, or
+// else once it expires.
+func (apiCfg *apiConfig) handlerUpdatePlan(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		MaxChirpLength      int32 `json:"max_chirp_length"`
+		CanEditChirps       bool  `json:"can_edit_chirps"`
+		DailyChirpQuota     int32 `json:"daily_chirp_quota"`
+		MaxMediaAttachments int32 `json:"max_media_attachments"`
+		RequestsPerMinute   int32 `json:"requests_per_minute"`
+	}
+
+	subject := authenticatedSubject(r)
+	if !authorize(w, subject, authz.ActionEdit, authz.Resource{Kind: authz.ResourcePlan}) {
+		return
+	}
+
+	name := r.PathValue("plan")
+	if !handlePattern.MatchString(name) {
+		respondWithError(w, http.StatusBadRequest, "Plan names must be 3-30 letters, digits or underscores")
+		return
+	}
+
+	params := parameters{}
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+
+	if params.MaxChirpLength <= 0 {
+		respondWithError(w, http.StatusBadRequest, "max_chirp_length must be positive")
+		return
+	}
+	if params.DailyChirpQuota < 0 || params.MaxMediaAttachments < 0 || params.RequestsPerMinute < 0 {
+		respondWithError(w, http.StatusBadRequest, "Limits can't be negative")
+		return
+	}
+
+	plan, err := apiCfg.DB.UpsertPlan(r.Context(), database.UpsertPlanParams{
+		Name:                name,
+		MaxChirpLength:      params.MaxChirpLength,
+		CanEditChirps:       params.CanEditChirps,
+		DailyChirpQuota:     params.DailyChirpQuota,
+		MaxMediaAttachments: params.MaxMediaAttachments,
+		RequestsPerMinute:   params.RequestsPerMinute,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Could not save plan")
+		return
+	}
+	apiCfg.Entitlements.Invalidate(plan.Name)
+	apiCfg.publishEvent(r.Context(), eventPlanUpdated, planUpdated{Name: plan.Name})
+
+	respondWithJSON(w, http.StatusOK, databasePlanToPlan(plan))
+}
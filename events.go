@@ -0,0 +1,155 @@
+package main
+
+import (
+	"chirpy/internal/database"
+	"chirpy/internal/events"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Domain events sent on the event bus. Every instance handles every event,
+// including the one that published it.
+const (
+	eventChirpCreated = "chirp.created"
+	eventChirpDeleted = "chirp.deleted"
+	eventUserUpgraded = "user.upgraded"
+	eventPlanUpdated  = "plan.updated"
+	eventMetricsHits  = "metrics.hits"
+	eventMetricsReset = "metrics.reset"
+)
+
+const (
+	eventChannel = "chirpy_events"
+	// metricsFlushInterval is how often each instance shares its
+	// fileserver hits, and so how far behind /admin/metrics may be on the
+	// others.
+	metricsFlushInterval = time.Second * 5
+)
+
+type chirpDeletion struct {
+	ID        uuid.UUID `json:"id"`
+	UserID    uuid.UUID `json:"user_id"`
+	DeletedAt time.Time `json:"deleted_at"`
+}
+
+type userUpgraded struct {
+	UserID uuid.UUID `json:"user_id"`
+}
+
+type planUpdated struct {
+	Name string `json:"name"`
+}
+
+type metricsHits struct {
+	Hits int64 `json:"hits"`
+}
+
+func databaseChirpToDeletion(chirp database.Chirp) chirpDeletion {
+	return chirpDeletion{
+		ID:        chirp.ID,
+		UserID:    chirp.UserID,
+		DeletedAt: chirp.DeletedAt.Time,
+	}
+}
+
+// eventBusFromEnv picks the event bus. EVENT_BUS=postgres shares events
+// between every instance on the database; anything else keeps them within
+// this process, which is only right for a single instance.
+func eventBusFromEnv(db *database.Queries, dbURL string) (events.Bus, error) {
+	switch bus := os.Getenv("EVENT_BUS"); bus {
+	case "", "local":
+		return events.NewLocal(), nil
+	case "postgres":
+		return events.NewPostgres(db, dbURL, eventChannel)
+	default:
+		return nil, fmt.Errorf("unknown EVENT_BUS %q", bus)
+	}
+}
+
+// publishEvent sends a domain event. The change it describes has already
+// been made, so a failure is only logged: other instances catch up when
+// their caches expire.
+func (apiCfg *apiConfig) publishEvent(ctx context.Context, eventType string, data any) {
+	if err := apiCfg.Events.Publish(ctx, eventType, data); err != nil {
+		log.Printf("Error publishing %s event: %s", eventType, err)
+	}
+}
+
+// handleEvent keeps this instance's in-memory state in step with events
+// from every instance.
+func (apiCfg *apiConfig) handleEvent(e events.Event) {
+	switch e.Type {
+	case eventChirpCreated:
+		var chirp Chirp
+		if decodeEvent(e, &chirp) {
+			apiCfg.Stream.Publish(chirpCreatedEvent(chirp))
+		}
+	case eventChirpDeleted:
+		var deletion chirpDeletion
+		if decodeEvent(e, &deletion) {
+			apiCfg.Stream.Publish(chirpDeletedEvent(deletion))
+		}
+	case eventUserUpgraded:
+		// Start a fresh window so the new plan's rate limit applies now.
+		var upgrade userUpgraded
+		if decodeEvent(e, &upgrade) {
+			apiCfg.RateLimiter.Reset(upgrade.UserID.String())
+		}
+	case eventPlanUpdated:
+		var plan planUpdated
+		if decodeEvent(e, &plan) {
+			apiCfg.Entitlements.Invalidate(plan.Name)
+		}
+	case eventMetricsHits:
+		var hits metricsHits
+		if decodeEvent(e, &hits) {
+			apiCfg.totalHits.Add(hits.Hits)
+		}
+	case eventMetricsReset:
+		apiCfg.totalHits.Store(0)
+		apiCfg.fileserverHits.Store(0)
+	case events.Reconnected:
+		// Plan changes may have been missed while the bus was down.
+		apiCfg.Entitlements.InvalidateAll()
+	}
+}
+
+func decodeEvent(e events.Event, v any) bool {
+	if err := json.Unmarshal(e.Data, v); err != nil {
+		log.Printf("Ignoring malformed %s event: %s", e.Type, err)
+		return false
+	}
+	return true
+}
+
+// runMetricsFlush publishes this instance's new fileserver hits every
+// interval until ctx is done.
+func (apiCfg *apiConfig) runMetricsFlush(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		hits := apiCfg.fileserverHits.Swap(0)
+		if hits == 0 {
+			continue
+		}
+		err := apiCfg.Events.Publish(ctx, eventMetricsHits, metricsHits{Hits: int64(hits)})
+		if err != nil {
+			log.Printf("Error publishing fileserver hits: %s", err)
+			// Keep them for the next flush.
+			apiCfg.fileserverHits.Add(hits)
+		}
+	}
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"chirpy/internal/auth"
+	"chirpy/internal/authz"
+	"chirpy/internal/database"
+	"net/http"
+
+	"github.com/google/uuid"
+)
+
+func (apiCfg *apiConfig) handlerFollowUser(w http.ResponseWriter, r *http.Request) {
+	followeeID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	subject := authenticatedSubject(r)
+
+	if !authorize(w, subject, authz.ActionEdit, authz.Resource{Kind: authz.ResourceUser, OwnerID: subject.UserID}) {
+		return
+	}
+	userId := subject.UserID
+
+	if userId == followeeID {
+		respondWithError(w, http.StatusBadRequest, "You cannot follow yourself")
+		return
+	}
+
+	if _, err := apiCfg.DB.GetUserFromId(r.Context(), followeeID); err != nil {
+		respondWithError(w, http.StatusNotFound, "User not found")
+		return
+	}
+
+	rows, err := apiCfg.DB.FollowUser(r.Context(), database.FollowUserParams{
+		FollowerID: userId,
+		FolloweeID: followeeID,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Could not follow user")
+		return
+	}
+	// Following someone again is a no-op, and not news to them.
+	if rows > 0 {
+		apiCfg.publishWebhookEvent(r.Context(), webhookUserFollowed, uuid.NullUUID{UUID: followeeID, Valid: true}, map[string]uuid.UUID{
+			"follower_id": userId,
+			"followee_id": followeeID,
+		})
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (apiCfg *apiConfig) handlerUnfollowUser(w http.ResponseWriter, r *http.Request) {
+	followeeID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	subject := authenticatedSubject(r)
+
+	if !authorize(w, subject, authz.ActionEdit, authz.Resource{Kind: authz.ResourceUser, OwnerID: subject.UserID}) {
+		return
+	}
+	userId := subject.UserID
+
+	rows, err := apiCfg.DB.UnfollowUser(r.Context(), database.UnfollowUserParams{
+		FollowerID: userId,
+		FolloweeID: followeeID,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Could not unfollow user")
+		return
+	}
+	if rows == 0 {
+		respondWithError(w, http.StatusNotFound, "You are not following this user")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (apiCfg *apiConfig) handlerGetFollowers(w http.ResponseWriter, r *http.Request) {
+	userID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	params, err := parsePageParams(r.URL.Query())
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	follows, err := fetchPage(params, true, func(p pageParams, limit int32, ascending bool) ([]database.Follow, error) {
+		if ascending {
+			return apiCfg.DB.ListFollowersAfter(r.Context(), database.ListFollowersAfterParams{
+				UserID:          userID,
+				CursorCreatedAt: p.cursorTime(),
+				CursorID:        p.cursorID(),
+				Limit:           limit,
+			})
+		}
+		return apiCfg.DB.ListFollowersBefore(r.Context(), database.ListFollowersBeforeParams{
+			UserID:          userID,
+			CursorCreatedAt: p.cursorTime(),
+			CursorID:        p.cursorID(),
+			Limit:           limit,
+		})
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	setPageLinks(w, r, follows, followerCursor)
+	respondWithJSON(w, http.StatusOK, databaseFollowsToFollowers(follows.Items))
+}
+
+func (apiCfg *apiConfig) handlerGetFollowing(w http.ResponseWriter, r *http.Request) {
+	userID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	params, err := parsePageParams(r.URL.Query())
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	follows, err := fetchPage(params, true, func(p pageParams, limit int32, ascending bool) ([]database.Follow, error) {
+		if ascending {
+			return apiCfg.DB.ListFollowingAfter(r.Context(), database.ListFollowingAfterParams{
+				UserID:          userID,
+				CursorCreatedAt: p.cursorTime(),
+				CursorID:        p.cursorID(),
+				Limit:           limit,
+			})
+		}
+		return apiCfg.DB.ListFollowingBefore(r.Context(), database.ListFollowingBeforeParams{
+			UserID:          userID,
+			CursorCreatedAt: p.cursorTime(),
+			CursorID:        p.cursorID(),
+			Limit:           limit,
+		})
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	setPageLinks(w, r, follows, followeeCursor)
+	respondWithJSON(w, http.StatusOK, databaseFollowsToFollowing(follows.Items))
+}
+
+func (apiCfg *apiConfig) handlerGetTimeline(w http.ResponseWriter, r *http.Request) {
+	subject := authenticatedSubject(r)
+	if !requireScope(w, subject, auth.ScopeChirpsRead) {
+		return
+	}
+	userId := subject.UserID
+
+	params, err := parsePageParams(r.URL.Query())
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	chirps, err := fetchPage(params, true, func(p pageParams, limit int32, ascending bool) ([]database.Chirp, error) {
+		if ascending {
+			return apiCfg.DB.ListTimelineAfter(r.Context(), database.ListTimelineAfterParams{
+				UserID:          userId,
+				CursorCreatedAt: p.cursorTime(),
+				CursorID:        p.cursorID(),
+				Limit:           limit,
+			})
+		}
+		return apiCfg.DB.ListTimelineBefore(r.Context(), database.ListTimelineBeforeParams{
+			UserID:          userId,
+			CursorCreatedAt: p.cursorTime(),
+			CursorID:        p.cursorID(),
+			Limit:           limit,
+		})
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	response := databaseChirpsToChirps(chirps.Items)
+	if wantsAuthor(r) {
+		if err := apiCfg.embedAuthors(r.Context(), chirpPointers(response)); err != nil {
+			respondWithError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+
+	setPageLinks(w, r, chirps, chirpCursor)
+	respondWithJSON(w, http.StatusOK, response)
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"chirpy/internal/auth"
+	"chirpy/internal/database"
+	"chirpy/internal/mailer"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const magicLinkLifetime = time.Minute * 15
+
+var errMagicLinkThrottled = errors.New("Too many login links requested, try again later")
+
+// magicLinkPage asks the user to confirm before the link is used. Mail
+// scanners open links in emails too, and must not use up a single-use link.
+var magicLinkPage = template.Must(template.New("magic_link").Parse(`<!DOCTYPE html>
+<html>
+  <head>
+    <meta charset="utf-8">
+    <title>Log in - Chirpy</title>
+  </head>
+  <body>
+    <h1>Log in to Chirpy</h1>
+    <form method="post" action="/api/login/magic/verify">
+      <input type="hidden" name="token" value="{{.}}">
+      <button type="submit">Log in</button>
+    </form>
+  </body>
+</html>
+`))
+
+// handlerRequestMagicLink emails a single-use login link. Like a password
+// reset, the response is the same whether or not the email is registered.
+func (apiCfg *apiConfig) handlerRequestMagicLink(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Email string `json:"email"`
+	}
+
+	params := parameters{}
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+
+	key := magicLinkThrottle.key(strings.ToLower(strings.TrimSpace(params.Email)))
+	wait, err := apiCfg.throttleRetryAfter(r.Context(), key)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error sending login link")
+		return
+	}
+	if wait > 0 {
+		w.Header().Set("Retry-After", retryAfterSeconds(wait))
+		respondWithError(w, http.StatusTooManyRequests, errMagicLinkThrottled.Error())
+		return
+	}
+	apiCfg.recordThrottledAttempt(r.Context(), magicLinkThrottle, key)
+
+	go apiCfg.sendMagicLinkEmail(params.Email)
+
+	respondWithJSON(w, http.StatusAccepted, map[string]string{
+		"message": "If that email is registered, a login link is on its way.",
+	})
+}
+
+func (apiCfg *apiConfig) sendMagicLinkEmail(email string) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
+	defer cancel()
+
+	user, err := apiCfg.DB.GetUserFromEmail(ctx, email)
+	if err != nil {
+		return
+	}
+
+	token, err := auth.MakeRandomToken()
+	if err != nil {
+		log.Printf("Error creating login link for user %s: %s", user.ID, err)
+		return
+	}
+
+	_, err = apiCfg.DB.CreateMagicLinkToken(ctx, database.CreateMagicLinkTokenParams{
+		TokenHash: auth.HashToken(token),
+		UserID:    user.ID,
+		ExpiresAt: time.Now().Add(magicLinkLifetime),
+	})
+	if err != nil {
+		log.Printf("Error storing login link for user %s: %s", user.ID, err)
+		return
+	}
+
+	link := apiCfg.BaseURL + "/api/login/magic/verify?token=" + url.QueryEscape(token)
+	err = apiCfg.Mailer.Send(ctx, mailer.Message{
+		To:      user.Email,
+		Subject: "Your Chirpy login link",
+		Body: fmt.Sprintf("Someone asked to log in to your Chirpy account.\n\n"+
+			"Open this link within %d minutes to log in:\n\n%s\n\n"+
+			"If it wasn't you, you can ignore this email.\n",
+			int(magicLinkLifetime.Minutes()), link),
+	})
+	if err != nil {
+		log.Printf("Error sending login link to user %s: %s", user.ID, err)
+	}
+}
+
+// handlerShowMagicLink is where the emailed link lands. It only shows a
+// button that posts the token to handlerMagicLinkLogin.
+func (apiCfg *apiConfig) handlerShowMagicLink(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		respondWithError(w, http.StatusBadRequest, "Missing token")
+		return
+	}
+
+	setPageSecurityHeaders(w)
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	if err := magicLinkPage.Execute(w, token); err != nil {
+		log.Printf("Error rendering login link page: %s", err)
+	}
+}
+
+// handlerMagicLinkLogin uses up a login link and logs its user in just like
+// /api/login does. The token can be posted as JSON or from the link's page.
+func (apiCfg *apiConfig) handlerMagicLinkLogin(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Token            string `json:"token"`
+		ExpiresInSeconds int    `json:"expires_in_seconds"`
+	}
+
+	params := parameters{}
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/x-www-form-urlencoded") {
+		params.Token = r.PostFormValue("token")
+	} else if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+
+	if params.Token == "" {
+		respondWithError(w, http.StatusBadRequest, "Token is required")
+		return
+	}
+
+	tx, err := apiCfg.DBConn.BeginTx(r.Context(), nil)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Could not log in")
+		return
+	}
+	defer tx.Rollback()
+	qtx := apiCfg.DB.WithTx(tx)
+
+	magicLink, err := qtx.ConsumeMagicLinkToken(r.Context(), auth.HashToken(params.Token))
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Login link is invalid or has expired")
+		return
+	}
+
+	if err := qtx.InvalidateMagicLinkTokens(r.Context(), magicLink.UserID); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Could not log in")
+		return
+	}
+
+	user, err := qtx.GetUserFromId(r.Context(), magicLink.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Could not log in")
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Could not log in")
+		return
+	}
+
+	if apiCfg.requiresSecondFactor(w, r, user) {
+		return
+	}
+	apiCfg.completeLogin(w, r, user, apiCfg.Tokens.accessTTL(params.ExpiresInSeconds))
+}
@@ -0,0 +1,587 @@
+package main
+
+import (
+	"chirpy/internal/auth"
+	"chirpy/internal/database"
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const authorizationCodeLifetime = time.Minute * 5
+
+var scopeDescriptions = map[string]string{
+	auth.ScopeChirpsRead:   "Read your home timeline",
+	auth.ScopeChirpsWrite:  "Post and delete chirps as you",
+	auth.ScopeProfileWrite: "Edit your profile and follow users",
+	auth.ScopeWebhooks:     "Be notified of your chirps, followers and upgrades",
+}
+
+var consentPage = template.Must(template.New("consent").Parse(`<!DOCTYPE html>
+<html>
+  <head>
+    <meta charset="utf-8">
+    <title>Authorize {{.Client.Name}} - Chirpy</title>
+  </head>
+  <body>
+    <h1>Authorize {{.Client.Name}}</h1>
+    <p><strong>{{.Client.Name}}</strong> would like to:</p>
+    <ul>
+      {{range .Scopes}}<li>{{.}}</li>{{end}}
+    </ul>
+    {{if .Error}}<p role="alert"><strong>{{.Error}}</strong></p>{{end}}
+    <form method="post" action="/oauth/authorize">
+      <input type="hidden" name="response_type" value="code">
+      <input type="hidden" name="client_id" value="{{.Request.ClientID}}">
+      <input type="hidden" name="redirect_uri" value="{{.Request.RedirectURI}}">
+      <input type="hidden" name="scope" value="{{.Request.Scope}}">
+      <input type="hidden" name="state" value="{{.Request.State}}">
+      <input type="hidden" name="code_challenge" value="{{.Request.CodeChallenge}}">
+      <input type="hidden" name="code_challenge_method" value="S256">
+      <p><label>Email <input type="email" name="email" value="{{.Email}}" autocomplete="username"></label></p>
+      <p><label>Password <input type="password" name="password" autocomplete="current-password"></label></p>
+      <p><label>Two-factor code (if enabled) <input type="text" name="code" inputmode="numeric" autocomplete="one-time-code"></label></p>
+      <p>You will be sent back to {{.Request.RedirectURI}}</p>
+      <button type="submit" name="decision" value="approve">Allow</button>
+      <button type="submit" name="decision" value="deny">Deny</button>
+    </form>
+  </body>
+</html>
+`))
+
+var authorizeErrorPage = template.Must(template.New("authorize_error").Parse(`<!DOCTYPE html>
+<html>
+  <head>
+    <meta charset="utf-8">
+    <title>Authorization failed - Chirpy</title>
+  </head>
+  <body>
+    <h1>Authorization failed</h1>
+    <p>{{.}}</p>
+  </body>
+</html>
+`))
+
+// oauthError is an error response as defined by RFC 6749 section 5.2.
+type oauthError struct {
+	Code        string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+}
+
+func (e oauthError) Error() string {
+	return e.Code + ": " + e.Description
+}
+
+func respondWithOAuthError(w http.ResponseWriter, code int, err oauthError) {
+	respondWithJSON(w, code, err)
+}
+
+// authorizeRequest is a validated request to /oauth/authorize.
+type authorizeRequest struct {
+	ClientID      uuid.UUID
+	RedirectURI   string
+	Scope         string
+	State         string
+	CodeChallenge string
+}
+
+// parseAuthorizeRequest validates the parameters of an authorization
+// request. Problems with the client or redirect URI come back as an
+// unsafeRedirectError, because the user must not be sent to an unverified
+// redirect URI; anything else is an oauthError to redirect back with.
+func (apiCfg *apiConfig) parseAuthorizeRequest(ctx context.Context, values url.Values) (authorizeRequest, database.OauthClient, error) {
+	req := authorizeRequest{State: values.Get("state")}
+
+	clientID, err := uuid.Parse(values.Get("client_id"))
+	if err != nil {
+		return req, database.OauthClient{}, unsafeRedirectError{"Unknown client"}
+	}
+	client, err := apiCfg.DB.GetOAuthClient(ctx, clientID)
+	if err != nil {
+		return req, database.OauthClient{}, unsafeRedirectError{"Unknown client"}
+	}
+	req.ClientID = client.ID
+
+	registered := strings.Fields(client.RedirectUris)
+	req.RedirectURI = values.Get("redirect_uri")
+	if req.RedirectURI == "" && len(registered) == 1 {
+		req.RedirectURI = registered[0]
+	}
+	if !slices.Contains(registered, req.RedirectURI) {
+		return req, client, unsafeRedirectError{"The redirect URI is not registered for this client"}
+	}
+
+	if values.Get("response_type") != "code" {
+		return req, client, oauthError{"unsupported_response_type", "response_type must be code"}
+	}
+
+	scopes := auth.ParseScope(values.Get("scope"))
+	if len(scopes) == 0 {
+		scopes = auth.ParseScope(client.Scope)
+	}
+	for _, scope := range scopes {
+		if !slices.Contains(auth.ParseScope(client.Scope), scope) {
+			return req, client, oauthError{"invalid_scope", "scope " + scope + " is not allowed for this client"}
+		}
+	}
+	req.Scope = auth.FormatScope(scopes)
+
+	req.CodeChallenge = values.Get("code_challenge")
+	if err := auth.ValidatePKCEChallenge(req.CodeChallenge, values.Get("code_challenge_method")); err != nil {
+		return req, client, oauthError{"invalid_request", err.Error()}
+	}
+
+	return req, client, nil
+}
+
+type unsafeRedirectError struct {
+	reason string
+}
+
+func (e unsafeRedirectError) Error() string {
+	return e.reason
+}
+
+// redirectWithParams sends the user agent back to the client's redirect URI
+// with params added to its query, along with the issuer as RFC 9207 asks.
+func (apiCfg *apiConfig) redirectWithParams(w http.ResponseWriter, r *http.Request, req authorizeRequest, params url.Values) {
+	u, err := url.Parse(req.RedirectURI)
+	if err != nil {
+		renderAuthorizeError(w, http.StatusBadRequest, "The redirect URI is invalid")
+		return
+	}
+
+	query := u.Query()
+	for key, values := range params {
+		query[key] = values
+	}
+	if req.State != "" {
+		query.Set("state", req.State)
+	}
+	query.Set("iss", apiCfg.BaseURL)
+	u.RawQuery = query.Encode()
+
+	http.Redirect(w, r, u.String(), http.StatusFound)
+}
+
+func (apiCfg *apiConfig) redirectWithOAuthError(w http.ResponseWriter, r *http.Request, req authorizeRequest, oerr oauthError) {
+	params := url.Values{"error": {oerr.Code}}
+	if oerr.Description != "" {
+		params.Set("error_description", oerr.Description)
+	}
+	apiCfg.redirectWithParams(w, r, req, params)
+}
+
+// setPageSecurityHeaders keeps the consent page out of frames, so it cannot
+// be overlaid to trick a user into approving a client.
+func setPageSecurityHeaders(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; frame-ancestors 'none'")
+}
+
+func renderAuthorizeError(w http.ResponseWriter, code int, message string) {
+	setPageSecurityHeaders(w)
+	w.WriteHeader(code)
+	if err := authorizeErrorPage.Execute(w, message); err != nil {
+		log.Printf("Error rendering authorization error page: %s", err)
+	}
+}
+
+func renderConsentPage(w http.ResponseWriter, code int, req authorizeRequest, client database.OauthClient, email, message string) {
+	scopes := []string{}
+	for _, scope := range auth.ParseScope(req.Scope) {
+		scopes = append(scopes, scopeDescriptions[scope])
+	}
+
+	setPageSecurityHeaders(w)
+	w.WriteHeader(code)
+	err := consentPage.Execute(w, map[string]any{
+		"Client":  client,
+		"Request": req,
+		"Scopes":  scopes,
+		"Email":   email,
+		"Error":   message,
+	})
+	if err != nil {
+		log.Printf("Error rendering consent page: %s", err)
+	}
+}
+
+// handlerAuthorize shows the consent page for an authorization code request.
+func (apiCfg *apiConfig) handlerAuthorize(w http.ResponseWriter, r *http.Request) {
+	req, client, err := apiCfg.parseAuthorizeRequest(r.Context(), r.URL.Query())
+	var badRedirect unsafeRedirectError
+	if errors.As(err, &badRedirect) {
+		renderAuthorizeError(w, http.StatusBadRequest, badRedirect.reason)
+		return
+	}
+	var oerr oauthError
+	if errors.As(err, &oerr) {
+		apiCfg.redirectWithOAuthError(w, r, req, oerr)
+		return
+	}
+
+	renderConsentPage(w, http.StatusOK, req, client, "", "")
+}
+
+// handlerApproveAuthorization handles the consent form. The user signs in
+// on the form itself, with their second factor if they have one, and is
+// sent back to the client with an authorization code if they allow it.
+func (apiCfg *apiConfig) handlerApproveAuthorization(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		renderAuthorizeError(w, http.StatusBadRequest, "The form could not be read")
+		return
+	}
+
+	req, client, err := apiCfg.parseAuthorizeRequest(r.Context(), r.PostForm)
+	var badRedirect unsafeRedirectError
+	if errors.As(err, &badRedirect) {
+		renderAuthorizeError(w, http.StatusBadRequest, badRedirect.reason)
+		return
+	}
+	var oerr oauthError
+	if errors.As(err, &oerr) {
+		apiCfg.redirectWithOAuthError(w, r, req, oerr)
+		return
+	}
+
+	if r.PostForm.Get("decision") != "approve" {
+		apiCfg.redirectWithOAuthError(w, r, req, oauthError{"access_denied", "The user denied the request"})
+		return
+	}
+
+	email := r.PostForm.Get("email")
+	user, err := apiCfg.checkPassword(r, email, r.PostForm.Get("password"))
+	var throttled loginThrottledError
+	switch {
+	case errors.As(err, &throttled):
+		w.Header().Set("Retry-After", retryAfterSeconds(throttled.wait))
+		renderConsentPage(w, http.StatusTooManyRequests, req, client, email, err.Error())
+		return
+	case errors.Is(err, errInvalidCredentials):
+		renderConsentPage(w, http.StatusUnauthorized, req, client, email, err.Error())
+		return
+	case err != nil:
+		renderAuthorizeError(w, http.StatusInternalServerError, "Something went wrong, please try again")
+		return
+	}
+
+	totp, err := apiCfg.DB.GetUserTOTP(r.Context(), user.ID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		log.Printf("Error loading two-factor settings for user %s: %s", user.ID, err)
+		apiCfg.redirectWithOAuthError(w, r, req, oauthError{"server_error", "Something went wrong, please try again"})
+		return
+	}
+	if err == nil && totp.Enabled {
+		if err := apiCfg.checkSecondFactor(r.Context(), user.ID, r.PostForm.Get("code"), ""); err != nil {
+			renderConsentPage(w, http.StatusUnauthorized, req, client, email, err.Error())
+			return
+		}
+	}
+
+	code, err := auth.MakeRandomToken()
+	if err != nil {
+		renderAuthorizeError(w, http.StatusInternalServerError, "Something went wrong, please try again")
+		return
+	}
+
+	err = apiCfg.DB.CreateAuthorizationCode(r.Context(), database.CreateAuthorizationCodeParams{
+		CodeHash:      auth.HashToken(code),
+		ClientID:      client.ID,
+		UserID:        user.ID,
+		RedirectUri:   req.RedirectURI,
+		Scope:         req.Scope,
+		CodeChallenge: req.CodeChallenge,
+		FamilyID:      uuid.New(),
+		ExpiresAt:     time.Now().Add(authorizationCodeLifetime),
+	})
+	if err != nil {
+		renderAuthorizeError(w, http.StatusInternalServerError, "Something went wrong, please try again")
+		return
+	}
+
+	apiCfg.redirectWithParams(w, r, req, url.Values{"code": {code}})
+}
+
+// authenticateClient identifies the client calling the token, introspection
+// or revocation endpoint, from HTTP Basic credentials or the client_id and
+// client_secret form fields. Public clients identify themselves by ID only.
+func (apiCfg *apiConfig) authenticateClient(r *http.Request) (database.OauthClient, error) {
+	invalid := oauthError{"invalid_client", "Client authentication failed"}
+
+	rawID, secret, basic := r.BasicAuth()
+	if basic {
+		// RFC 6749 form-encodes the credentials before they go into the header.
+		rawID, _ = url.QueryUnescape(rawID)
+		secret, _ = url.QueryUnescape(secret)
+	} else {
+		rawID, secret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+
+	clientID, err := uuid.Parse(rawID)
+	if err != nil {
+		return database.OauthClient{}, invalid
+	}
+	client, err := apiCfg.DB.GetOAuthClient(r.Context(), clientID)
+	if err != nil {
+		return database.OauthClient{}, invalid
+	}
+
+	if !client.SecretHash.Valid {
+		if secret != "" {
+			return database.OauthClient{}, invalid
+		}
+		return client, nil
+	}
+
+	if subtle.ConstantTimeCompare([]byte(auth.HashToken(secret)), []byte(client.SecretHash.String)) != 1 {
+		return database.OauthClient{}, invalid
+	}
+	return client, nil
+}
+
+// handlerToken is the OAuth token endpoint. It exchanges authorization codes
+// and refresh tokens for access tokens, using the same refresh token
+// families, rotation and reuse detection as /api/refresh.
+func (apiCfg *apiConfig) handlerToken(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+
+	if err := r.ParseForm(); err != nil {
+		respondWithOAuthError(w, http.StatusBadRequest, oauthError{"invalid_request", "Could not read the request body"})
+		return
+	}
+
+	client, err := apiCfg.authenticateClient(r)
+	if err != nil {
+		if _, _, basic := r.BasicAuth(); basic {
+			w.Header().Set("WWW-Authenticate", `Basic realm="chirpy"`)
+		}
+		respondWithOAuthError(w, http.StatusUnauthorized, err.(oauthError))
+		return
+	}
+
+	switch r.PostForm.Get("grant_type") {
+	case "authorization_code":
+		apiCfg.exchangeAuthorizationCode(w, r, client)
+	case "refresh_token":
+		apiCfg.exchangeClientRefreshToken(w, r, client)
+	default:
+		respondWithOAuthError(w, http.StatusBadRequest, oauthError{"unsupported_grant_type", "grant_type must be authorization_code or refresh_token"})
+	}
+}
+
+func (apiCfg *apiConfig) exchangeAuthorizationCode(w http.ResponseWriter, r *http.Request, client database.OauthClient) {
+	invalidGrant := oauthError{"invalid_grant", "The authorization code is invalid or has expired"}
+
+	codeHash := auth.HashToken(r.PostForm.Get("code"))
+	code, err := apiCfg.DB.GetAuthorizationCode(r.Context(), codeHash)
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithOAuthError(w, http.StatusBadRequest, invalidGrant)
+		return
+	}
+	if err != nil {
+		respondWithOAuthError(w, http.StatusInternalServerError, oauthError{"server_error", ""})
+		return
+	}
+
+	if code.ClientID != client.ID || time.Now().After(code.ExpiresAt) {
+		respondWithOAuthError(w, http.StatusBadRequest, invalidGrant)
+		return
+	}
+
+	if code.RedirectUri != r.PostForm.Get("redirect_uri") {
+		respondWithOAuthError(w, http.StatusBadRequest, oauthError{"invalid_grant", "redirect_uri does not match the authorization request"})
+		return
+	}
+
+	if !auth.VerifyPKCE(r.PostForm.Get("code_verifier"), code.CodeChallenge) {
+		respondWithOAuthError(w, http.StatusBadRequest, oauthError{"invalid_grant", "code_verifier does not match the code challenge"})
+		return
+	}
+
+	used, err := apiCfg.DB.UseAuthorizationCode(r.Context(), codeHash)
+	if err != nil {
+		respondWithOAuthError(w, http.StatusInternalServerError, oauthError{"server_error", ""})
+		return
+	}
+	if used == 0 {
+		// The code was already exchanged, so someone else may hold it too.
+		// Revoke whatever the first exchange issued, as RFC 6749 advises.
+		log.Printf("Authorization code reuse detected for client %s, revoking token family %s", client.ID, code.FamilyID)
+		if err := apiCfg.DB.RevokeTokenFamily(r.Context(), code.FamilyID); err != nil {
+			log.Printf("Error revoking token family %s: %s", code.FamilyID, err)
+		}
+		respondWithOAuthError(w, http.StatusBadRequest, invalidGrant)
+		return
+	}
+
+	clientID := uuid.NullUUID{UUID: client.ID, Valid: true}
+	scopes := auth.ParseScope(code.Scope)
+	refreshToken, err := apiCfg.issueRefreshToken(r, code.UserID, code.FamilyID, clientID, scopes)
+	if err != nil {
+		respondWithOAuthError(w, http.StatusInternalServerError, oauthError{"server_error", ""})
+		return
+	}
+
+	apiCfg.respondWithClientTokens(w, code.UserID, code.FamilyID, client.ID, scopes, refreshToken)
+}
+
+func (apiCfg *apiConfig) exchangeClientRefreshToken(w http.ResponseWriter, r *http.Request, client database.OauthClient) {
+	clientID := uuid.NullUUID{UUID: client.ID, Valid: true}
+	stored, refreshToken, err := apiCfg.rotateRefreshToken(r, r.PostForm.Get("refresh_token"), clientID)
+	if errors.Is(err, errInvalidRefreshToken) || errors.Is(err, errRefreshTokenExpired) {
+		respondWithOAuthError(w, http.StatusBadRequest, oauthError{"invalid_grant", err.Error()})
+		return
+	}
+	if err != nil {
+		respondWithOAuthError(w, http.StatusInternalServerError, oauthError{"server_error", ""})
+		return
+	}
+
+	apiCfg.respondWithClientTokens(w, stored.UserID, stored.FamilyID, client.ID, auth.ParseScope(stored.Scope), refreshToken)
+}
+
+func (apiCfg *apiConfig) respondWithClientTokens(w http.ResponseWriter, userID, familyID, clientID uuid.UUID, scopes []string, refreshToken string) {
+	accessToken, err := auth.MakeClientJWT(userID, apiCfg.Keys, familyID, clientID, apiCfg.Tokens.AccessTTL, scopes)
+	if err != nil {
+		respondWithOAuthError(w, http.StatusInternalServerError, oauthError{"server_error", ""})
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, map[string]any{
+		"access_token":  accessToken,
+		"token_type":    "Bearer",
+		"expires_in":    int64(apiCfg.Tokens.AccessTTL.Seconds()),
+		"refresh_token": refreshToken,
+		"scope":         auth.FormatScope(scopes),
+	})
+}
+
+// handlerIntrospect reports whether a token is active, as RFC 7662
+// describes. Only confidential clients may ask. Access tokens are inactive
+// once their session has been revoked, even before they expire.
+func (apiCfg *apiConfig) handlerIntrospect(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		respondWithOAuthError(w, http.StatusBadRequest, oauthError{"invalid_request", "Could not read the request body"})
+		return
+	}
+
+	client, err := apiCfg.authenticateClient(r)
+	if err != nil || !client.SecretHash.Valid {
+		respondWithOAuthError(w, http.StatusUnauthorized, oauthError{"invalid_client", "Client authentication failed"})
+		return
+	}
+
+	inactive := map[string]any{"active": false}
+	token := r.PostForm.Get("token")
+
+	if claims, err := auth.ParseJWT(token, apiCfg.Keys); err == nil {
+		if sessionID := auth.SessionIDFromClaims(claims); sessionID != uuid.Nil {
+			active, err := apiCfg.DB.IsSessionActive(r.Context(), sessionID)
+			if err != nil || !active {
+				respondWithJSON(w, http.StatusOK, inactive)
+				return
+			}
+		}
+
+		response := map[string]any{
+			"active":     true,
+			"token_type": "Bearer",
+			"scope":      claims.Scope,
+			"sub":        claims.Subject,
+			"iss":        claims.Issuer,
+			"aud":        claims.Audience,
+			"exp":        claims.ExpiresAt.Unix(),
+			"iat":        claims.IssuedAt.Unix(),
+		}
+		if claims.ClientID != "" {
+			response["client_id"] = claims.ClientID
+		}
+		respondWithJSON(w, http.StatusOK, response)
+		return
+	}
+
+	// Refresh tokens are only described to the client they were issued to.
+	stored, err := apiCfg.DB.GetRefreshToken(r.Context(), auth.HashRefreshToken(token))
+	if err != nil || stored.RevokedAt.Valid || time.Now().After(stored.ExpiresAt) || stored.ClientID.UUID != client.ID {
+		respondWithJSON(w, http.StatusOK, inactive)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, map[string]any{
+		"active":    true,
+		"scope":     stored.Scope,
+		"client_id": client.ID.String(),
+		"sub":       stored.UserID.String(),
+		"exp":       stored.ExpiresAt.Unix(),
+	})
+}
+
+// handlerOAuthRevoke revokes a token issued to the calling client, as RFC
+// 7009 describes. Revoking either an access or a refresh token ends the
+// whole session it belongs to. Unknown tokens are not an error.
+func (apiCfg *apiConfig) handlerOAuthRevoke(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		respondWithOAuthError(w, http.StatusBadRequest, oauthError{"invalid_request", "Could not read the request body"})
+		return
+	}
+
+	client, err := apiCfg.authenticateClient(r)
+	if err != nil {
+		respondWithOAuthError(w, http.StatusUnauthorized, err.(oauthError))
+		return
+	}
+
+	token := r.PostForm.Get("token")
+	familyID := uuid.Nil
+	if claims, err := auth.ParseJWT(token, apiCfg.Keys); err == nil {
+		if auth.ClientIDFromClaims(claims) == client.ID {
+			familyID = auth.SessionIDFromClaims(claims)
+		}
+	} else if stored, err := apiCfg.DB.GetRefreshToken(r.Context(), auth.HashRefreshToken(token)); err == nil {
+		if stored.ClientID.Valid && stored.ClientID.UUID == client.ID {
+			familyID = stored.FamilyID
+		}
+	}
+
+	if familyID != uuid.Nil {
+		if err := apiCfg.DB.RevokeTokenFamily(r.Context(), familyID); err != nil {
+			respondWithOAuthError(w, http.StatusServiceUnavailable, oauthError{"temporarily_unavailable", ""})
+			return
+		}
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// handlerOAuthMetadata publishes the authorization server metadata from RFC
+// 8414, so client libraries can configure themselves.
+func (apiCfg *apiConfig) handlerOAuthMetadata(w http.ResponseWriter, r *http.Request) {
+	respondWithJSON(w, http.StatusOK, map[string]any{
+		"issuer":                                         apiCfg.BaseURL,
+		"authorization_endpoint":                         apiCfg.BaseURL + "/oauth/authorize",
+		"token_endpoint":                                 apiCfg.BaseURL + "/oauth/token",
+		"introspection_endpoint":                         apiCfg.BaseURL + "/oauth/introspect",
+		"revocation_endpoint":                            apiCfg.BaseURL + "/oauth/revoke",
+		"jwks_uri":                                       apiCfg.BaseURL + "/.well-known/jwks.json",
+		"scopes_supported":                               auth.ThirdPartyScopes,
+		"response_types_supported":                       []string{"code"},
+		"grant_types_supported":                          []string{"authorization_code", "refresh_token"},
+		"code_challenge_methods_supported":               []string{"S256"},
+		"token_endpoint_auth_methods_supported":          []string{"client_secret_basic", "client_secret_post", "none"},
+		"authorization_response_iss_parameter_supported": true,
+	})
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"chirpy/internal/auth"
+	"chirpy/internal/database"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	maxClientNameLength   = 100
+	maxClientRedirectURIs = 10
+)
+
+type OAuthClient struct {
+	ID           uuid.UUID `json:"client_id"`
+	Name         string    `json:"name"`
+	RedirectURIs []string  `json:"redirect_uris"`
+	Scopes       []string  `json:"scopes"`
+	Public       bool      `json:"public"`
+	CreatedAt    time.Time `json:"created_at"`
+	// ClientSecret is only set in the response to registering a
+	// confidential client.
+	ClientSecret string `json:"client_secret,omitempty"`
+}
+
+func databaseClientToOAuthClient(dbClient database.OauthClient) OAuthClient {
+	return OAuthClient{
+		ID:           dbClient.ID,
+		Name:         dbClient.Name,
+		RedirectURIs: strings.Fields(dbClient.RedirectUris),
+		Scopes:       auth.ParseScope(dbClient.Scope),
+		Public:       !dbClient.SecretHash.Valid,
+		CreatedAt:    dbClient.CreatedAt,
+	}
+}
+
+// handlerCreateOAuthClient registers a third-party app. Confidential clients
+// get a secret, shown only in this response; public clients, which cannot
+// keep one, authenticate with PKCE alone.
+func (apiCfg *apiConfig) handlerCreateOAuthClient(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Name         string   `json:"name"`
+		RedirectURIs []string `json:"redirect_uris"`
+		Scopes       []string `json:"scopes"`
+		Public       bool     `json:"public"`
+	}
+
+	subject := authenticatedSubject(r)
+	if !requireScope(w, subject, auth.ScopeAccount) {
+		return
+	}
+
+	params := parameters{}
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+
+	params.Name = strings.TrimSpace(params.Name)
+	if params.Name == "" || len(params.Name) > maxClientNameLength {
+		respondWithError(w, http.StatusBadRequest, "Name must be between 1 and 100 characters")
+		return
+	}
+
+	if len(params.RedirectURIs) == 0 || len(params.RedirectURIs) > maxClientRedirectURIs {
+		respondWithError(w, http.StatusBadRequest, "Between 1 and 10 redirect URIs are required")
+		return
+	}
+	for _, uri := range params.RedirectURIs {
+		if strings.ContainsAny(uri, " \t\n") {
+			respondWithError(w, http.StatusBadRequest, "Redirect URIs must not contain whitespace")
+			return
+		}
+		if err := auth.ValidateRedirectURI(uri); err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	if err := auth.ValidateClientScopes(params.Scopes); err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	secret := ""
+	secretHash := sql.NullString{}
+	if !params.Public {
+		var err error
+		secret, err = auth.MakeRandomToken()
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Could not register client")
+			return
+		}
+		secretHash = sql.NullString{String: auth.HashToken(secret), Valid: true}
+	}
+
+	dbClient, err := apiCfg.DB.CreateOAuthClient(r.Context(), database.CreateOAuthClientParams{
+		OwnerID:      subject.UserID,
+		Name:         params.Name,
+		SecretHash:   secretHash,
+		RedirectUris: strings.Join(params.RedirectURIs, " "),
+		Scope:        auth.FormatScope(params.Scopes),
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Could not register client")
+		return
+	}
+
+	response := databaseClientToOAuthClient(dbClient)
+	response.ClientSecret = secret
+	respondWithJSON(w, http.StatusCreated, response)
+}
+
+func (apiCfg *apiConfig) handlerListOAuthClients(w http.ResponseWriter, r *http.Request) {
+	subject := authenticatedSubject(r)
+	if !requireScope(w, subject, auth.ScopeAccount) {
+		return
+	}
+
+	dbClients, err := apiCfg.DB.ListOAuthClients(r.Context(), subject.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Could not list clients")
+		return
+	}
+
+	clients := []OAuthClient{}
+	for _, dbClient := range dbClients {
+		clients = append(clients, databaseClientToOAuthClient(dbClient))
+	}
+
+	respondWithJSON(w, http.StatusOK, clients)
+}
+
+// handlerDeleteOAuthClient removes a client. Every code and refresh token
+// issued to it goes with it.
+func (apiCfg *apiConfig) handlerDeleteOAuthClient(w http.ResponseWriter, r *http.Request) {
+	clientID, err := uuid.Parse(r.PathValue("clientID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid client ID")
+		return
+	}
+
+	subject := authenticatedSubject(r)
+	if !requireScope(w, subject, auth.ScopeAccount) {
+		return
+	}
+
+	rows, err := apiCfg.DB.DeleteOAuthClient(r.Context(), database.DeleteOAuthClientParams{
+		ID:      clientID,
+		OwnerID: subject.UserID,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Could not delete client")
+		return
+	}
+	if rows == 0 {
+		respondWithError(w, http.StatusNotFound, "Client not found")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
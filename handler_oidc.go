@@ -0,0 +1,297 @@
+package main
+
+import (
+	"chirpy/internal/auth"
+	"chirpy/internal/database"
+	"chirpy/internal/oidc"
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+const oidcLoginLifetime = time.Minute * 10
+
+const oidcStateCookie = "chirpy_oidc_state"
+
+var providerNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,31}$`)
+
+// oidcProvidersFromEnv reads the providers named in OIDC_PROVIDERS. Each one
+// is configured with OIDC_<NAME>_ISSUER, _CLIENT_ID, _CLIENT_SECRET and
+// optionally _SCOPES, where <NAME> is the provider name in upper case with
+// dashes turned into underscores. Discovery happens lazily, on the first
+// login, so a provider that is down doesn't stop Chirpy from starting.
+func oidcProvidersFromEnv(baseURL string, leeway time.Duration) (map[string]*oidc.Provider, error) {
+	providers := map[string]*oidc.Provider{}
+	for _, name := range splitList(os.Getenv("OIDC_PROVIDERS")) {
+		if !providerNamePattern.MatchString(name) {
+			return nil, fmt.Errorf("invalid OIDC provider name %q", name)
+		}
+		if _, ok := providers[name]; ok {
+			return nil, fmt.Errorf("OIDC provider %q is listed twice", name)
+		}
+
+		prefix := "OIDC_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_")) + "_"
+		issuer := os.Getenv(prefix + "ISSUER")
+		clientID := os.Getenv(prefix + "CLIENT_ID")
+		if issuer == "" || clientID == "" {
+			return nil, fmt.Errorf("OIDC provider %q needs %sISSUER and %sCLIENT_ID", name, prefix, prefix)
+		}
+
+		provider := oidc.NewProvider(name, issuer, clientID, os.Getenv(prefix+"CLIENT_SECRET"),
+			baseURL+"/api/oidc/"+name+"/callback", strings.Fields(os.Getenv(prefix+"SCOPES")))
+		if !slices.Contains(provider.Scopes, "openid") {
+			return nil, fmt.Errorf("%sSCOPES must include openid", prefix)
+		}
+		provider.Leeway = leeway
+		providers[name] = provider
+	}
+	return providers, nil
+}
+
+type OIDCProvider struct {
+	Name     string `json:"name"`
+	LoginURL string `json:"login_url"`
+}
+
+func (apiCfg *apiConfig) handlerListOIDCProviders(w http.ResponseWriter, r *http.Request) {
+	providers := []OIDCProvider{}
+	for name := range apiCfg.OIDC {
+		providers = append(providers, OIDCProvider{
+			Name:     name,
+			LoginURL: apiCfg.BaseURL + "/api/oidc/" + name + "/login",
+		})
+	}
+	slices.SortFunc(providers, func(a, b OIDCProvider) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+
+	respondWithJSON(w, http.StatusOK, providers)
+}
+
+// handlerOIDCLogin sends the browser to the provider. The state, nonce and
+// PKCE verifier are kept in the database, and the state also goes into a
+// cookie so that only the browser that started the login can finish it.
+func (apiCfg *apiConfig) handlerOIDCLogin(w http.ResponseWriter, r *http.Request) {
+	provider, ok := apiCfg.OIDC[r.PathValue("provider")]
+	if !ok {
+		respondWithError(w, http.StatusNotFound, "Unknown identity provider")
+		return
+	}
+
+	state, err := auth.MakeRandomToken()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error starting login")
+		return
+	}
+	nonce, err := auth.MakeRandomToken()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error starting login")
+		return
+	}
+	codeVerifier, err := auth.MakeRandomToken()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error starting login")
+		return
+	}
+
+	authURL, err := provider.AuthCodeURL(r.Context(), state, nonce, auth.PKCEChallenge(codeVerifier))
+	if err != nil {
+		log.Printf("Error starting OIDC login: %s", err)
+		respondWithError(w, http.StatusBadGateway, "Identity provider is unavailable")
+		return
+	}
+
+	if err := apiCfg.DB.DeleteExpiredOIDCLoginStates(r.Context()); err != nil {
+		log.Printf("Error deleting expired OIDC login states: %s", err)
+	}
+	err = apiCfg.DB.CreateOIDCLoginState(r.Context(), database.CreateOIDCLoginStateParams{
+		StateHash:    auth.HashToken(state),
+		Provider:     provider.Name,
+		Nonce:        nonce,
+		CodeVerifier: codeVerifier,
+		ExpiresAt:    time.Now().Add(oidcLoginLifetime),
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error starting login")
+		return
+	}
+
+	// Lax, not Strict: the provider sends the browser back with a top-level
+	// cross-site navigation, which Strict would strip the cookie from.
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    state,
+		Path:     "/api/oidc/" + provider.Name + "/callback",
+		MaxAge:   int(oidcLoginLifetime.Seconds()),
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(apiCfg.BaseURL, "https://"),
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// handlerOIDCCallback finishes the login the provider sent the browser back
+// from, then logs the matching Chirpy user in just like /api/login does.
+func (apiCfg *apiConfig) handlerOIDCCallback(w http.ResponseWriter, r *http.Request) {
+	provider, ok := apiCfg.OIDC[r.PathValue("provider")]
+	if !ok {
+		respondWithError(w, http.StatusNotFound, "Unknown identity provider")
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Path:     "/api/oidc/" + provider.Name + "/callback",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(apiCfg.BaseURL, "https://"),
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	query := r.URL.Query()
+	state := query.Get("state")
+	cookie, err := r.Cookie(oidcStateCookie)
+	if err != nil || state == "" || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(state)) != 1 {
+		respondWithError(w, http.StatusBadRequest, "Login state is missing or does not match")
+		return
+	}
+
+	loginState, err := apiCfg.DB.ConsumeOIDCLoginState(r.Context(), auth.HashToken(state))
+	if err != nil || loginState.Provider != provider.Name || time.Now().After(loginState.ExpiresAt) {
+		respondWithError(w, http.StatusBadRequest, "Login has expired, please start again")
+		return
+	}
+
+	if errCode := query.Get("error"); errCode != "" {
+		respondWithError(w, http.StatusUnauthorized, fmt.Sprintf("Sign-in was not completed: %s", errCode))
+		return
+	}
+
+	rawIDToken, err := provider.Exchange(r.Context(), query.Get("code"), loginState.CodeVerifier)
+	var tokenErr *oidc.TokenError
+	if errors.As(err, &tokenErr) {
+		respondWithError(w, http.StatusUnauthorized, fmt.Sprintf("Sign-in was not completed: %s", tokenErr.Code))
+		return
+	}
+	if err != nil {
+		log.Printf("Error exchanging OIDC code: %s", err)
+		respondWithError(w, http.StatusBadGateway, "Identity provider is unavailable")
+		return
+	}
+
+	idToken, err := provider.VerifyIDToken(r.Context(), rawIDToken, loginState.Nonce)
+	if err != nil {
+		log.Printf("Rejected OIDC login: %s", err)
+		respondWithError(w, http.StatusUnauthorized, "Identity provider returned an invalid ID token")
+		return
+	}
+
+	user, err := apiCfg.userForIdentity(r.Context(), provider.Name, idToken)
+	if errors.Is(err, errIdentityNotLinkable) {
+		respondWithError(w, http.StatusConflict, err.Error())
+		return
+	}
+	if errors.Is(err, errIdentityHasNoEmail) {
+		respondWithError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error signing in")
+		return
+	}
+
+	if apiCfg.requiresSecondFactor(w, r, user) {
+		return
+	}
+	apiCfg.completeLogin(w, r, user, apiCfg.Tokens.AccessTTL)
+}
+
+var (
+	errIdentityNotLinkable = errors.New("An account with this email already exists. Log in with your password to use it")
+	errIdentityHasNoEmail  = errors.New("Identity provider did not share an email address")
+)
+
+// userForIdentity returns the user an external identity signs in as. An
+// identity seen before maps to the same user. A new one is linked to the
+// account with the same email only when both the provider and Chirpy have
+// verified that email; otherwise someone who registered the address first
+// could take over the account. Failing that, a new user is created without
+// a password.
+func (apiCfg *apiConfig) userForIdentity(ctx context.Context, provider string, idToken *oidc.IDToken) (database.User, error) {
+	identity, err := apiCfg.DB.GetUserIdentity(ctx, database.GetUserIdentityParams{
+		Provider: provider,
+		Subject:  idToken.Subject,
+	})
+	if err == nil {
+		return apiCfg.DB.GetUserFromId(ctx, identity.UserID)
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return database.User{}, err
+	}
+
+	if validateEmail(idToken.Email) != nil {
+		return database.User{}, errIdentityHasNoEmail
+	}
+
+	tx, err := apiCfg.DBConn.BeginTx(ctx, nil)
+	if err != nil {
+		return database.User{}, err
+	}
+	defer tx.Rollback()
+	qtx := apiCfg.DB.WithTx(tx)
+
+	user, err := qtx.GetUserFromEmail(ctx, idToken.Email)
+	switch {
+	case err == nil:
+		if !idToken.EmailVerified || !user.Verified {
+			return database.User{}, errIdentityNotLinkable
+		}
+	case errors.Is(err, sql.ErrNoRows):
+		// An empty hash never matches a password, so the account can only
+		// be used through the provider until a password is set by reset.
+		user, err = qtx.CreateUser(ctx, database.CreateUserParams{
+			Email:          idToken.Email,
+			HashedPassword: "",
+			Handle:         defaultHandle(),
+		})
+		if err != nil {
+			return database.User{}, err
+		}
+		if idToken.EmailVerified {
+			user, err = qtx.VerifyUserEmail(ctx, database.VerifyUserEmailParams{ID: user.ID, Email: user.Email})
+			if err != nil {
+				return database.User{}, err
+			}
+		}
+	default:
+		return database.User{}, err
+	}
+
+	err = qtx.CreateUserIdentity(ctx, database.CreateUserIdentityParams{
+		Provider: provider,
+		Subject:  idToken.Subject,
+		UserID:   user.ID,
+		Email:    idToken.Email,
+	})
+	if err != nil {
+		return database.User{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return database.User{}, err
+	}
+
+	if !user.Verified {
+		apiCfg.sendVerificationEmail(ctx, user)
+	}
+	return user, nil
+}
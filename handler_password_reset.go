@@ -0,0 +1,172 @@
+package main
+
+import (
+	"chirpy/internal/auth"
+	"chirpy/internal/database"
+	"chirpy/internal/mailer"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const passwordResetLifetime = time.Hour
+
+var errPasswordResetThrottled = errors.New("Too many password resets requested, try again later")
+
+func (apiCfg *apiConfig) handlerForgotPassword(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Email string `json:"email"`
+	}
+
+	params := parameters{}
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+
+	key := passwordResetThrottle.key(strings.ToLower(strings.TrimSpace(params.Email)))
+	wait, err := apiCfg.throttleRetryAfter(r.Context(), key)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error sending password reset")
+		return
+	}
+	if wait > 0 {
+		w.Header().Set("Retry-After", retryAfterSeconds(wait))
+		respondWithError(w, http.StatusTooManyRequests, errPasswordResetThrottled.Error())
+		return
+	}
+	apiCfg.recordThrottledAttempt(r.Context(), passwordResetThrottle, key)
+
+	// The reset is issued after responding so that neither the body nor the
+	// response time tells the caller whether the email is registered.
+	go apiCfg.sendPasswordResetEmail(params.Email)
+
+	respondWithJSON(w, http.StatusAccepted, map[string]string{
+		"message": "If that email is registered, a password reset link is on its way.",
+	})
+}
+
+func (apiCfg *apiConfig) sendPasswordResetEmail(email string) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
+	defer cancel()
+
+	user, err := apiCfg.DB.GetUserFromEmail(ctx, email)
+	if err != nil {
+		return
+	}
+
+	token, err := auth.MakeRandomToken()
+	if err != nil {
+		log.Printf("Error creating password reset token for user %s: %s", user.ID, err)
+		return
+	}
+
+	_, err = apiCfg.DB.CreatePasswordResetToken(ctx, database.CreatePasswordResetTokenParams{
+		TokenHash: auth.HashToken(token),
+		UserID:    user.ID,
+		ExpiresAt: time.Now().Add(passwordResetLifetime),
+	})
+	if err != nil {
+		log.Printf("Error storing password reset token for user %s: %s", user.ID, err)
+		return
+	}
+
+	err = apiCfg.Mailer.Send(ctx, mailer.Message{
+		To:      user.Email,
+		Subject: "Reset your Chirpy password",
+		Body: fmt.Sprintf("Someone asked to reset the password for your Chirpy account.\n\n"+
+			"Use this token within %d minutes to choose a new one:\n\n%s\n\n"+
+			"If it wasn't you, you can ignore this email.\n",
+			int(passwordResetLifetime.Minutes()), token),
+	})
+	if err != nil {
+		log.Printf("Error sending password reset email to user %s: %s", user.ID, err)
+	}
+}
+
+// handlerResetPassword sets a new password from a reset token. The token,
+// every other outstanding reset token and every refresh token of the user
+// are invalidated together.
+func (apiCfg *apiConfig) handlerResetPassword(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+
+	params := parameters{}
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+
+	if params.Token == "" || params.Password == "" {
+		respondWithError(w, http.StatusBadRequest, "Token and password are required")
+		return
+	}
+
+	tx, err := apiCfg.DBConn.BeginTx(r.Context(), nil)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Could not reset password")
+		return
+	}
+	defer tx.Rollback()
+	qtx := apiCfg.DB.WithTx(tx)
+
+	resetToken, err := qtx.ConsumePasswordResetToken(r.Context(), auth.HashToken(params.Token))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Reset token is invalid or has expired")
+		return
+	}
+
+	user, err := qtx.GetUserFromId(r.Context(), resetToken.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Could not reset password")
+		return
+	}
+
+	// A rejected password rolls the transaction back, so the token can be
+	// used again with a better one.
+	if err := apiCfg.Passwords.Policy.Check(params.Password, user.Email); err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	hashed_password, err := apiCfg.Passwords.hash(params.Password)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error processing password")
+		return
+	}
+
+	err = qtx.UpdateUserPassword(r.Context(), database.UpdateUserPasswordParams{
+		ID:             resetToken.UserID,
+		HashedPassword: hashed_password,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Could not reset password")
+		return
+	}
+
+	if err := qtx.InvalidatePasswordResetTokens(r.Context(), resetToken.UserID); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Could not reset password")
+		return
+	}
+
+	if err := qtx.RevokeAllUserSessions(r.Context(), resetToken.UserID); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Could not reset password")
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Could not reset password")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"chirpy/internal/authz"
+	"chirpy/internal/database"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+const (
+	maxDisplayNameLength = 50
+	maxBioLength         = 160
+)
+
+var handlePattern = regexp.MustCompile(`^[A-Za-z0-9_]{3,30}$`)
+
+func validateHandle(handle string) error {
+	if !handlePattern.MatchString(handle) {
+		return errors.New("handle must be 3-30 letters, digits or underscores")
+	}
+	return nil
+}
+
+func validateAvatarURL(avatarURL string) error {
+	if avatarURL == "" {
+		return nil
+	}
+	u, err := url.Parse(avatarURL)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return errors.New("avatar_url must be an http or https URL")
+	}
+	return nil
+}
+
+// defaultHandle is assigned to accounts that sign up without choosing one.
+func defaultHandle() string {
+	return "user_" + strings.ReplaceAll(uuid.NewString(), "-", "")[:12]
+}
+
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505"
+}
+
+func (cfg *apiConfig) handlerGetProfile(w http.ResponseWriter, r *http.Request) {
+	user, err := cfg.DB.GetUserFromHandle(r.Context(), r.PathValue("handle"))
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "User not found")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, databaseUserToProfile(user))
+}
+
+func (apiCfg *apiConfig) handlerUpdateProfile(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Handle      *string `json:"handle"`
+		DisplayName *string `json:"display_name"`
+		Bio         *string `json:"bio"`
+		AvatarURL   *string `json:"avatar_url"`
+	}
+
+	subject := authenticatedSubject(r)
+
+	if !authorize(w, subject, authz.ActionEdit, authz.Resource{Kind: authz.ResourceUser, OwnerID: subject.UserID}) {
+		return
+	}
+	userId := subject.UserID
+
+	params := parameters{}
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+
+	if params.Handle != nil {
+		if err := validateHandle(*params.Handle); err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+	if params.DisplayName != nil && utf8.RuneCountInString(*params.DisplayName) > maxDisplayNameLength {
+		respondWithError(w, http.StatusBadRequest, "display_name is too long")
+		return
+	}
+	if params.Bio != nil && utf8.RuneCountInString(*params.Bio) > maxBioLength {
+		respondWithError(w, http.StatusBadRequest, "bio is too long")
+		return
+	}
+	if params.AvatarURL != nil {
+		if err := validateAvatarURL(*params.AvatarURL); err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	user, err := apiCfg.DB.UpdateUserProfile(r.Context(), database.UpdateUserProfileParams{
+		ID:          userId,
+		Handle:      nullString(params.Handle),
+		DisplayName: nullString(params.DisplayName),
+		Bio:         nullString(params.Bio),
+		AvatarUrl:   nullString(params.AvatarURL),
+	})
+	if isUniqueViolation(err) {
+		respondWithError(w, http.StatusConflict, "Handle is already taken")
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Could not update profile")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, apiCfg.userResponse(r.Context(), user))
+}
+
+func nullString(s *string) sql.NullString {
+	if s == nil {
+		return sql.NullString{}
+	}
+	return sql.NullString{String: *s, Valid: true}
+}
+
+// wantsAuthor reports whether the client asked for author summaries to be
+// embedded with ?expand=author.
+func wantsAuthor(r *http.Request) bool {
+	for _, expand := range strings.Split(r.URL.Query().Get("expand"), ",") {
+		if strings.TrimSpace(expand) == "author" {
+			return true
+		}
+	}
+	return false
+}
+
+// embedAuthors fills in the author summary of every chirp, including
+// replies nested in threads, with a single query. Deleted chirps are left
+// anonymous.
+func (cfg *apiConfig) embedAuthors(ctx context.Context, chirps []*Chirp) error {
+	ids := []uuid.UUID{}
+	for _, chirp := range chirps {
+		if !chirp.Deleted {
+			ids = append(ids, chirp.UserID)
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	authors, err := cfg.DB.GetAuthors(ctx, ids)
+	if err != nil {
+		return err
+	}
+
+	byID := make(map[uuid.UUID]AuthorSummary, len(authors))
+	for _, author := range authors {
+		byID[author.ID] = databaseAuthorToAuthorSummary(author)
+	}
+
+	for _, chirp := range chirps {
+		if author, ok := byID[chirp.UserID]; ok && !chirp.Deleted {
+			chirp.Author = &author
+		}
+	}
+	return nil
+}
+
+func chirpPointers(chirps []Chirp) []*Chirp {
+	ptrs := make([]*Chirp, len(chirps))
+	for i := range chirps {
+		ptrs[i] = &chirps[i]
+	}
+	return ptrs
+}
+
+func threadPointers(node *ThreadChirp) []*Chirp {
+	ptrs := []*Chirp{&node.Chirp}
+	for i := range node.Replies {
+		ptrs = append(ptrs, threadPointers(&node.Replies[i])...)
+	}
+	return ptrs
+}
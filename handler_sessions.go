@@ -0,0 +1,101 @@
+package main
+
+import (
+	"chirpy/internal/auth"
+	"chirpy/internal/database"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type Session struct {
+	ID         uuid.UUID `json:"id"`
+	UserAgent  string    `json:"user_agent"`
+	IPAddress  string    `json:"ip_address"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	Current    bool      `json:"current"`
+}
+
+// clientIP returns the address of the peer that sent r, without the port.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func (apiCfg *apiConfig) handlerListSessions(w http.ResponseWriter, r *http.Request) {
+	subject := authenticatedSubject(r)
+	if !requireScope(w, subject, auth.ScopeAccount) {
+		return
+	}
+
+	rows, err := apiCfg.DB.ListActiveSessions(r.Context(), subject.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Could not list sessions")
+		return
+	}
+
+	sessions := []Session{}
+	for _, row := range rows {
+		sessions = append(sessions, Session{
+			ID:         row.FamilyID,
+			UserAgent:  row.UserAgent,
+			IPAddress:  row.IpAddress,
+			CreatedAt:  row.StartedAt,
+			LastUsedAt: row.LastUsedAt,
+			Current:    row.FamilyID == subject.SessionID,
+		})
+	}
+
+	respondWithJSON(w, http.StatusOK, sessions)
+}
+
+func (apiCfg *apiConfig) handlerRevokeSession(w http.ResponseWriter, r *http.Request) {
+	sessionID, err := uuid.Parse(r.PathValue("sessionID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid session ID")
+		return
+	}
+
+	subject := authenticatedSubject(r)
+	if !requireScope(w, subject, auth.ScopeAccount) {
+		return
+	}
+
+	revoked, err := apiCfg.DB.RevokeUserSession(r.Context(), database.RevokeUserSessionParams{
+		UserID:   subject.UserID,
+		FamilyID: sessionID,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Could not revoke session")
+		return
+	}
+	if revoked == 0 {
+		respondWithError(w, http.StatusNotFound, "Session not found")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handlerRevokeAllSessions logs the user out everywhere, including the
+// session making the request. Access tokens that were already issued stay
+// valid until they expire.
+func (apiCfg *apiConfig) handlerRevokeAllSessions(w http.ResponseWriter, r *http.Request) {
+	subject := authenticatedSubject(r)
+	if !requireScope(w, subject, auth.ScopeAccount) {
+		return
+	}
+
+	if err := apiCfg.DB.RevokeAllUserSessions(r.Context(), subject.UserID); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Could not revoke sessions")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
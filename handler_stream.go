@@ -0,0 +1,432 @@
+package main
+
+import (
+	"chirpy/internal/auth"
+	"chirpy/internal/authz"
+	"chirpy/internal/database"
+	"chirpy/internal/stream"
+	"chirpy/internal/websocket"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// streamBuffer is how many events a client may fall behind before it
+	// is disconnected to catch up with Last-Event-ID.
+	streamBuffer       = 64
+	streamHeartbeat    = time.Second * 15
+	streamWriteTimeout = time.Second * 10
+	// A reconnecting client is sent the changes from the last
+	// maxStreamReplay, maxStreamReplayChirps chirps at a time.
+	maxStreamReplay       = time.Hour * 24
+	maxStreamReplayChirps = 500
+)
+
+var (
+	streamEventIDPattern = regexp.MustCompile(`^[0-9a-f]{16}-[0-9a-f-]{36}-[cd]$`)
+
+	errInvalidLastEventID = errors.New("Invalid Last-Event-ID")
+	errCredentialRevoked  = errors.New("credential revoked")
+)
+
+func chirpCreatedEvent(chirp Chirp) stream.Event {
+	data, _ := json.Marshal(chirp)
+	return stream.Event{
+		ID:       stream.EventID(chirp.CreatedAt, chirp.ID, 'c'),
+		Type:     eventChirpCreated,
+		AuthorID: chirp.UserID,
+		Data:     data,
+	}
+}
+
+func chirpDeletedEvent(deletion chirpDeletion) stream.Event {
+	data, _ := json.Marshal(deletion)
+	return stream.Event{
+		ID:       stream.EventID(deletion.DeletedAt, deletion.ID, 'd'),
+		Type:     eventChirpDeleted,
+		AuthorID: deletion.UserID,
+		Data:     data,
+	}
+}
+
+// chirpStream is a client's subscription to chirp changes, along with the
+// changes it missed while it was away. When those don't fit in one replay,
+// more is set: the stream ends after the replay and the client reconnects
+// for the next page, so nothing is skipped over by live events.
+//
+// The credential the stream was opened with is checked again on every
+// heartbeat, and expired ends the stream when it runs out.
+type chirpStream struct {
+	sub     *stream.Subscription
+	replay  []stream.Event
+	more    bool
+	subject authz.Subject
+	expired <-chan time.Time
+	timer   *time.Timer
+}
+
+// skip reports whether e was already sent in the replay. Events published
+// while the replay was loaded can show up in both. Live events are not
+// compared by ID: concurrent chirps can be published slightly out of
+// order.
+func (cs *chirpStream) skip(e stream.Event) bool {
+	return slices.ContainsFunc(cs.replay, func(r stream.Event) bool {
+		return r.ID == e.ID
+	})
+}
+
+// openChirpStream subscribes the caller to chirp changes, filtered with
+// ?author_id= or ?following=true, and loads whatever they missed since the
+// event in Last-Event-ID (or ?last_event_id=, for clients that can't set
+// headers). On failure it writes an error and returns false.
+func (apiCfg *apiConfig) openChirpStream(w http.ResponseWriter, r *http.Request) (*chirpStream, bool) {
+	subject := authenticatedSubject(r)
+	if !requireScope(w, subject, auth.ScopeChirpsRead) {
+		return nil, false
+	}
+
+	authorIDs, err := apiCfg.streamAuthors(r, subject)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return nil, false
+	}
+
+	lastID := r.Header.Get("Last-Event-ID")
+	if lastID == "" {
+		lastID = r.URL.Query().Get("last_event_id")
+	}
+	if lastID != "" && !streamEventIDPattern.MatchString(lastID) {
+		respondWithError(w, http.StatusBadRequest, errInvalidLastEventID.Error())
+		return nil, false
+	}
+
+	var filter func(stream.Event) bool
+	if authorIDs != nil {
+		filter = func(e stream.Event) bool {
+			return slices.Contains(authorIDs, e.AuthorID)
+		}
+	}
+	// Subscribe before loading the replay so nothing published in between
+	// is lost.
+	cs := &chirpStream{
+		sub:     apiCfg.Stream.Subscribe(streamBuffer, filter),
+		subject: subject,
+	}
+	if !subject.ExpiresAt.IsZero() {
+		cs.timer = time.NewTimer(time.Until(subject.ExpiresAt))
+		cs.expired = cs.timer.C
+	}
+
+	if lastID != "" {
+		cs.replay, cs.more, err = apiCfg.replayChirpEvents(r.Context(), lastID, authorIDs)
+		if err != nil {
+			apiCfg.closeChirpStream(cs)
+			respondWithError(w, http.StatusInternalServerError, "Could not load missed chirps")
+			return nil, false
+		}
+	}
+	return cs, true
+}
+
+func (apiCfg *apiConfig) closeChirpStream(cs *chirpStream) {
+	apiCfg.Stream.Unsubscribe(cs.sub)
+	if cs.timer != nil {
+		cs.timer.Stop()
+	}
+}
+
+// stillAuthorized checks that the stream's credential is still in force. It
+// returns errCredentialRevoked if it isn't, or the error that kept it from
+// finding out. Either way the stream must end; a client that reconnects
+// with Last-Event-ID misses nothing and is checked afresh.
+func (apiCfg *apiConfig) stillAuthorized(ctx context.Context, cs *chirpStream) error {
+	active, err := apiCfg.credentialActive(ctx, cs.subject)
+	if err != nil {
+		log.Printf("Error checking the credential of a stream for user %s: %s", cs.subject.UserID, err)
+		return err
+	}
+	if !active {
+		return errCredentialRevoked
+	}
+	return nil
+}
+
+// streamAuthors returns the authors whose chirps the caller asked for, or
+// nil for everyone's.
+func (apiCfg *apiConfig) streamAuthors(r *http.Request, subject authz.Subject) ([]uuid.UUID, error) {
+	query := r.URL.Query()
+	following := query.Get("following") == "true"
+	authorIDStr := query.Get("author_id")
+
+	switch {
+	case following && authorIDStr != "":
+		return nil, errors.New("Use either author_id or following, not both")
+	case authorIDStr != "":
+		authorID, err := uuid.Parse(authorIDStr)
+		if err != nil {
+			return nil, errors.New("Invalid author_id")
+		}
+		return []uuid.UUID{authorID}, nil
+	case following:
+		// The follow graph is read once. Follows made while the stream is
+		// open show up when the client reconnects.
+		followees, err := apiCfg.DB.ListFolloweeIDs(r.Context(), subject.UserID)
+		if err != nil {
+			return nil, err
+		}
+		if followees == nil {
+			followees = []uuid.UUID{}
+		}
+		return followees, nil
+	}
+	return nil, nil
+}
+
+// replayChirpEvents returns the changes after lastID in order, going back
+// no further than maxStreamReplay. If there are more than one page holds,
+// it returns the first page and reports that there are more.
+func (apiCfg *apiConfig) replayChirpEvents(ctx context.Context, lastID string, authorIDs []uuid.UUID) ([]stream.Event, bool, error) {
+	micros, err := strconv.ParseInt(lastID[:16], 16, 64)
+	if err != nil {
+		return nil, false, errInvalidLastEventID
+	}
+	since := time.UnixMicro(micros)
+	if oldest := time.Now().Add(-maxStreamReplay); since.Before(oldest) {
+		since = oldest
+	}
+
+	chirps, err := apiCfg.DB.ListChirpChangesSince(ctx, database.ListChirpChangesSinceParams{
+		Since:     since,
+		AuthorIds: authorIDs,
+		Limit:     maxStreamReplayChirps,
+	})
+	if err != nil {
+		return nil, false, err
+	}
+
+	// Chirps come in order of their first change since then, so on a full
+	// page every change up to the last chirp's first one is in it. Later
+	// changes may not be, and are left for the next page.
+	more := len(chirps) == maxStreamReplayChirps
+	var last string
+	if more {
+		chirp := chirps[len(chirps)-1]
+		if chirp.CreatedAt.Before(since) {
+			last = stream.EventID(chirp.DeletedAt.Time, chirp.ID, 'd')
+		} else {
+			last = stream.EventID(chirp.CreatedAt, chirp.ID, 'c')
+		}
+	}
+	inPage := func(e stream.Event) bool {
+		return e.ID > lastID && (!more || e.ID <= last)
+	}
+
+	events := []stream.Event{}
+	for _, chirp := range chirps {
+		if e := chirpCreatedEvent(databaseChirpToChirp(chirp)); inPage(e) {
+			events = append(events, e)
+		}
+		if chirp.DeletedAt.Valid {
+			if e := chirpDeletedEvent(databaseChirpToDeletion(chirp)); inPage(e) {
+				events = append(events, e)
+			}
+		}
+	}
+	slices.SortFunc(events, func(a, b stream.Event) int {
+		return strings.Compare(a.ID, b.ID)
+	})
+	return events, more, nil
+}
+
+// handlerStreamChirps pushes chirp changes as Server-Sent Events. Browsers'
+// EventSource reconnects by itself and sends Last-Event-ID to resume.
+func (apiCfg *apiConfig) handlerStreamChirps(w http.ResponseWriter, r *http.Request) {
+	cs, ok := apiCfg.openChirpStream(w, r)
+	if !ok {
+		return
+	}
+	defer apiCfg.closeChirpStream(cs)
+
+	rc := http.NewResponseController(w)
+	write := func(format string, args ...any) error {
+		rc.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+		if _, err := fmt.Fprintf(w, format, args...); err != nil {
+			return err
+		}
+		return rc.Flush()
+	}
+	send := func(e stream.Event) error {
+		return write("id: %s\nevent: %s\ndata: %s\n\n", e.ID, e.Type, e.Data)
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	if err := write("retry: 3000\n\n"); err != nil {
+		return
+	}
+
+	for _, e := range cs.replay {
+		if err := send(e); err != nil {
+			return
+		}
+	}
+	if cs.more {
+		// EventSource reconnects from the last replayed event.
+		return
+	}
+
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case e, ok := <-cs.sub.Events():
+			if !ok {
+				// Dropped for falling behind. The client reconnects and
+				// picks up from its last event.
+				return
+			}
+			if cs.skip(e) {
+				continue
+			}
+			if err := send(e); err != nil {
+				return
+			}
+		case <-cs.expired:
+			// The client gets a 401 when it reconnects with the same
+			// credential.
+			return
+		case <-heartbeat.C:
+			if err := apiCfg.stillAuthorized(r.Context(), cs); err != nil {
+				return
+			}
+			if err := write(": heartbeat\n\n"); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// streamMessage is a chirp change as sent over the WebSocket.
+type streamMessage struct {
+	ID    string          `json:"id"`
+	Event string          `json:"event"`
+	Data  json.RawMessage `json:"data"`
+}
+
+// sameOrigin reports whether a browser request came from one of our own
+// pages. Browsers send cookies with cross-site WebSocket handshakes, so
+// they have to be checked. Requests without an Origin don't come from a
+// browser page.
+func (apiCfg *apiConfig) sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" || origin == apiCfg.BaseURL {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && u.Host == r.Host
+}
+
+// handlerStreamChirpsWebSocket pushes the same changes as
+// handlerStreamChirps over a WebSocket, one JSON message per change.
+func (apiCfg *apiConfig) handlerStreamChirpsWebSocket(w http.ResponseWriter, r *http.Request) {
+	if !apiCfg.sameOrigin(r) {
+		respondWithError(w, http.StatusForbidden, "Cross-origin WebSocket connections are not allowed")
+		return
+	}
+	if !websocket.IsUpgrade(r) {
+		w.Header().Set("Upgrade", "websocket")
+		respondWithError(w, http.StatusUpgradeRequired, "This endpoint only speaks WebSocket")
+		return
+	}
+
+	cs, ok := apiCfg.openChirpStream(w, r)
+	if !ok {
+		return
+	}
+	defer apiCfg.closeChirpStream(cs)
+
+	conn, err := websocket.Upgrade(w, r)
+	if err != nil {
+		if !errors.Is(err, websocket.ErrBadHandshake) {
+			log.Printf("Error upgrading stream connection: %s", err)
+		}
+		return
+	}
+	defer conn.Close()
+
+	// The client has to answer pings, so a silent connection is dead.
+	closed := make(chan error, 1)
+	go func() {
+		closed <- conn.ReadLoop(streamHeartbeat * 2)
+	}()
+
+	send := func(e stream.Event) error {
+		message, err := json.Marshal(streamMessage{ID: e.ID, Event: e.Type, Data: e.Data})
+		if err != nil {
+			return err
+		}
+		return conn.WriteText(message, time.Now().Add(streamWriteTimeout))
+	}
+
+	for _, e := range cs.replay {
+		if err := send(e); err != nil {
+			return
+		}
+	}
+	if cs.more {
+		conn.CloseWithCode(websocket.CloseTryAgainLater, "more to replay, reconnect with last_event_id", time.Now().Add(time.Second))
+		return
+	}
+
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-closed:
+			return
+		case e, ok := <-cs.sub.Events():
+			if !ok {
+				conn.CloseWithCode(websocket.CloseTryAgainLater, "fell behind, reconnect with last_event_id", time.Now().Add(time.Second))
+				return
+			}
+			if cs.skip(e) {
+				continue
+			}
+			if err := send(e); err != nil {
+				return
+			}
+		case <-cs.expired:
+			conn.CloseWithCode(websocket.ClosePolicyViolation, "credential expired", time.Now().Add(time.Second))
+			return
+		case <-heartbeat.C:
+			if err := apiCfg.stillAuthorized(r.Context(), cs); errors.Is(err, errCredentialRevoked) {
+				conn.CloseWithCode(websocket.ClosePolicyViolation, "credential revoked", time.Now().Add(time.Second))
+				return
+			} else if err != nil {
+				conn.CloseWithCode(websocket.CloseTryAgainLater, "could not check credential, reconnect with last_event_id", time.Now().Add(time.Second))
+				return
+			}
+			if err := conn.Ping(time.Now().Add(streamWriteTimeout)); err != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"chirpy/internal/auth"
+	"chirpy/internal/database"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	maxTokenNameLength = 100
+	maxTokenLifetime   = time.Hour * 24 * 365
+)
+
+type PersonalAccessToken struct {
+	ID         uuid.UUID  `json:"id"`
+	Name       string     `json:"name"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	// Token is only set in the response to creating the token.
+	Token string `json:"token,omitempty"`
+}
+
+func databaseTokenToPersonalAccessToken(dbToken database.PersonalAccessToken) PersonalAccessToken {
+	token := PersonalAccessToken{
+		ID:        dbToken.ID,
+		Name:      dbToken.Name,
+		Scopes:    auth.ParseScope(dbToken.Scope),
+		CreatedAt: dbToken.CreatedAt,
+	}
+	if dbToken.ExpiresAt.Valid {
+		token.ExpiresAt = &dbToken.ExpiresAt.Time
+	}
+	if dbToken.LastUsedAt.Valid {
+		token.LastUsedAt = &dbToken.LastUsedAt.Time
+	}
+	return token
+}
+
+// handlerCreateToken issues a personal access token for scripts and bots.
+// Only its hash is stored, so the response is the one chance to copy it.
+func (apiCfg *apiConfig) handlerCreateToken(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Name          string   `json:"name"`
+		Scopes        []string `json:"scopes"`
+		ExpiresInDays int      `json:"expires_in_days"`
+	}
+
+	subject := authenticatedSubject(r)
+	if !requireScope(w, subject, auth.ScopeAccount) {
+		return
+	}
+
+	params := parameters{}
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+
+	params.Name = strings.TrimSpace(params.Name)
+	if params.Name == "" || len(params.Name) > maxTokenNameLength {
+		respondWithError(w, http.StatusBadRequest, "Name must be between 1 and 100 characters")
+		return
+	}
+
+	if err := auth.ValidateScopes(params.Scopes); err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	expiresAt := sql.NullTime{}
+	if params.ExpiresInDays < 0 {
+		respondWithError(w, http.StatusBadRequest, "expires_in_days must be positive")
+		return
+	}
+	if params.ExpiresInDays > 0 {
+		lifetime := time.Duration(params.ExpiresInDays) * time.Hour * 24
+		if lifetime > maxTokenLifetime {
+			respondWithError(w, http.StatusBadRequest, "Tokens can last at most 365 days")
+			return
+		}
+		expiresAt = sql.NullTime{Time: time.Now().Add(lifetime), Valid: true}
+	}
+
+	token, err := auth.MakePersonalAccessToken()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Could not create token")
+		return
+	}
+
+	dbToken, err := apiCfg.DB.CreatePersonalAccessToken(r.Context(), database.CreatePersonalAccessTokenParams{
+		UserID:    subject.UserID,
+		Name:      params.Name,
+		TokenHash: auth.HashToken(token),
+		Scope:     auth.FormatScope(params.Scopes),
+		ExpiresAt: expiresAt,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Could not create token")
+		return
+	}
+
+	response := databaseTokenToPersonalAccessToken(dbToken)
+	response.Token = token
+	respondWithJSON(w, http.StatusCreated, response)
+}
+
+func (apiCfg *apiConfig) handlerListTokens(w http.ResponseWriter, r *http.Request) {
+	subject := authenticatedSubject(r)
+	if !requireScope(w, subject, auth.ScopeAccount) {
+		return
+	}
+
+	dbTokens, err := apiCfg.DB.ListPersonalAccessTokens(r.Context(), subject.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Could not list tokens")
+		return
+	}
+
+	tokens := []PersonalAccessToken{}
+	for _, dbToken := range dbTokens {
+		tokens = append(tokens, databaseTokenToPersonalAccessToken(dbToken))
+	}
+
+	respondWithJSON(w, http.StatusOK, tokens)
+}
+
+func (apiCfg *apiConfig) handlerRevokeToken(w http.ResponseWriter, r *http.Request) {
+	tokenID, err := uuid.Parse(r.PathValue("tokenID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid token ID")
+		return
+	}
+
+	subject := authenticatedSubject(r)
+	if !requireScope(w, subject, auth.ScopeAccount) {
+		return
+	}
+
+	rows, err := apiCfg.DB.RevokePersonalAccessToken(r.Context(), database.RevokePersonalAccessTokenParams{
+		ID:     tokenID,
+		UserID: subject.UserID,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Could not revoke token")
+		return
+	}
+	if rows == 0 {
+		respondWithError(w, http.StatusNotFound, "Token not found")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
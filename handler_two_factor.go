@@ -0,0 +1,323 @@
+package main
+
+import (
+	"chirpy/internal/auth"
+	"chirpy/internal/database"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	mfaChallengeLifetime = time.Minute * 5
+	maxTOTPFailures      = 5
+	totpLockout          = time.Minute * 15
+	recoveryCodeCount    = 10
+	totpIssuer           = "Chirpy"
+)
+
+var (
+	errSecondFactorInvalid = errors.New("Invalid two-factor code")
+	errSecondFactorLocked  = errors.New("Too many invalid two-factor codes, try again later")
+)
+
+type MFAChallenge struct {
+	MFARequired    bool   `json:"mfa_required"`
+	ChallengeToken string `json:"challenge_token"`
+}
+
+func (apiCfg *apiConfig) handlerEnrollTOTP(w http.ResponseWriter, r *http.Request) {
+	subject := authenticatedSubject(r)
+	if !requireScope(w, subject, auth.ScopeAccount) {
+		return
+	}
+
+	totp, err := apiCfg.DB.GetUserTOTP(r.Context(), subject.UserID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, http.StatusInternalServerError, "Could not start enrollment")
+		return
+	}
+	if err == nil && totp.Enabled {
+		respondWithError(w, http.StatusConflict, "Two-factor authentication is already enabled")
+		return
+	}
+
+	user, err := apiCfg.DB.GetUserFromId(r.Context(), subject.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error retrieving user")
+		return
+	}
+
+	secret, err := auth.GenerateTOTPSecret()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Could not start enrollment")
+		return
+	}
+
+	_, err = apiCfg.DB.UpsertPendingTOTP(r.Context(), database.UpsertPendingTOTPParams{
+		UserID: subject.UserID,
+		Secret: secret,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Could not start enrollment")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, map[string]string{
+		"secret":      secret,
+		"otpauth_uri": auth.TOTPURI(secret, totpIssuer, user.Email),
+	})
+}
+
+// handlerConfirmTOTP turns 2FA on once the user proves their authenticator
+// produces valid codes, and returns the recovery codes. They are only
+// stored hashed, so this is the one time the user can see them.
+func (apiCfg *apiConfig) handlerConfirmTOTP(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Code string `json:"code"`
+	}
+
+	subject := authenticatedSubject(r)
+	if !requireScope(w, subject, auth.ScopeAccount) {
+		return
+	}
+
+	params := parameters{}
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+
+	totp, err := apiCfg.DB.GetUserTOTP(r.Context(), subject.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Start enrollment first")
+		return
+	}
+	if totp.Enabled {
+		respondWithError(w, http.StatusConflict, "Two-factor authentication is already enabled")
+		return
+	}
+
+	step, ok := auth.ValidateTOTP(totp.Secret, params.Code, time.Now())
+	if !ok {
+		respondWithError(w, http.StatusBadRequest, errSecondFactorInvalid.Error())
+		return
+	}
+
+	tx, err := apiCfg.DBConn.BeginTx(r.Context(), nil)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Could not enable two-factor authentication")
+		return
+	}
+	defer tx.Rollback()
+	qtx := apiCfg.DB.WithTx(tx)
+
+	_, err = qtx.RecordTOTPUse(r.Context(), database.RecordTOTPUseParams{
+		UserID:       subject.UserID,
+		LastUsedStep: step,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Could not enable two-factor authentication")
+		return
+	}
+
+	if err := qtx.EnableUserTOTP(r.Context(), subject.UserID); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Could not enable two-factor authentication")
+		return
+	}
+
+	if err := qtx.DeleteRecoveryCodes(r.Context(), subject.UserID); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Could not enable two-factor authentication")
+		return
+	}
+
+	codes := make([]string, 0, recoveryCodeCount)
+	for range recoveryCodeCount {
+		code, err := auth.MakeRecoveryCode()
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Could not enable two-factor authentication")
+			return
+		}
+		err = qtx.CreateRecoveryCode(r.Context(), database.CreateRecoveryCodeParams{
+			UserID:   subject.UserID,
+			CodeHash: auth.HashToken(code),
+		})
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Could not enable two-factor authentication")
+			return
+		}
+		codes = append(codes, code)
+	}
+
+	if err := tx.Commit(); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Could not enable two-factor authentication")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, map[string][]string{
+		"recovery_codes": codes,
+	})
+}
+
+func (apiCfg *apiConfig) handlerDisableTOTP(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+
+	subject := authenticatedSubject(r)
+	if !requireScope(w, subject, auth.ScopeAccount) {
+		return
+	}
+
+	params := parameters{}
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+
+	if err := apiCfg.checkSecondFactor(r.Context(), subject.UserID, params.Code, params.RecoveryCode); err != nil {
+		respondWithError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	if err := apiCfg.DB.DeleteUserTOTP(r.Context(), subject.UserID); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Could not disable two-factor authentication")
+		return
+	}
+	if err := apiCfg.DB.DeleteRecoveryCodes(r.Context(), subject.UserID); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Could not disable two-factor authentication")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handlerLoginTwoFactor is the second step of logging in to an account with
+// 2FA: it trades the challenge token from POST /api/login plus a code for
+// the usual access and refresh tokens.
+func (apiCfg *apiConfig) handlerLoginTwoFactor(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		ChallengeToken   string `json:"challenge_token"`
+		Code             string `json:"code"`
+		RecoveryCode     string `json:"recovery_code"`
+		ExpiresInSeconds int    `json:"expires_in_seconds"`
+	}
+
+	params := parameters{}
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+
+	userID, err := auth.ValidateMFAChallengeToken(params.ChallengeToken, apiCfg.Keys)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Challenge token is invalid or has expired")
+		return
+	}
+
+	if err := apiCfg.checkSecondFactor(r.Context(), userID, params.Code, params.RecoveryCode); err != nil {
+		respondWithError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	user, err := apiCfg.DB.GetUserFromId(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Challenge token is invalid or has expired")
+		return
+	}
+
+	apiCfg.completeLogin(w, r, user, apiCfg.Tokens.accessTTL(params.ExpiresInSeconds))
+}
+
+// checkSecondFactor accepts either a TOTP code or an unused recovery code.
+// A TOTP code is only accepted once. After maxTOTPFailures wrong codes in a
+// row every code is refused for totpLockout, and each wrong code after that
+// starts the lockout again until a right one is given.
+func (apiCfg *apiConfig) checkSecondFactor(ctx context.Context, userID uuid.UUID, code, recoveryCode string) error {
+	totp, err := apiCfg.DB.GetUserTOTP(ctx, userID)
+	if err != nil || !totp.Enabled {
+		return errors.New("Two-factor authentication is not enabled")
+	}
+
+	if totp.LockedUntil.Valid && time.Now().Before(totp.LockedUntil.Time) {
+		return errSecondFactorLocked
+	}
+
+	if recoveryCode != "" {
+		used, err := apiCfg.DB.UseRecoveryCode(ctx, database.UseRecoveryCodeParams{
+			UserID:   userID,
+			CodeHash: auth.HashToken(auth.NormalizeRecoveryCode(recoveryCode)),
+		})
+		if err == nil && used == 1 {
+			return apiCfg.DB.ResetTOTPFailures(ctx, userID)
+		}
+		apiCfg.recordSecondFactorFailure(ctx, userID)
+		return errSecondFactorInvalid
+	}
+
+	step, ok := auth.ValidateTOTP(totp.Secret, code, time.Now())
+	if ok {
+		accepted, err := apiCfg.DB.RecordTOTPUse(ctx, database.RecordTOTPUseParams{
+			UserID:       userID,
+			LastUsedStep: step,
+		})
+		if err != nil {
+			return err
+		}
+		if accepted == 1 {
+			return nil
+		}
+	}
+
+	apiCfg.recordSecondFactorFailure(ctx, userID)
+	return errSecondFactorInvalid
+}
+
+func (apiCfg *apiConfig) recordSecondFactorFailure(ctx context.Context, userID uuid.UUID) {
+	_, err := apiCfg.DB.RecordTOTPFailure(ctx, database.RecordTOTPFailureParams{
+		MaxFailures: maxTOTPFailures,
+		LockedUntil: time.Now().Add(totpLockout),
+		UserID:      userID,
+	})
+	if err != nil {
+		log.Printf("Error recording a failed two-factor code for user %s: %s", userID, err)
+	}
+}
+
+// requiresSecondFactor starts the 2FA step of a login if the user has it
+// enabled. It reports whether it has written a response, either the
+// challenge or an error; the login must stop there if so. A failure to
+// find out whether 2FA is enabled stops the login too.
+func (apiCfg *apiConfig) requiresSecondFactor(w http.ResponseWriter, r *http.Request, user database.User) bool {
+	totp, err := apiCfg.DB.GetUserTOTP(r.Context(), user.ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false
+	}
+	if err != nil {
+		log.Printf("Error loading two-factor settings for user %s: %s", user.ID, err)
+		respondWithError(w, http.StatusInternalServerError, "Error starting two-factor login")
+		return true
+	}
+	if !totp.Enabled {
+		return false
+	}
+
+	challenge, err := auth.MakeMFAChallengeToken(user.ID, apiCfg.Keys, mfaChallengeLifetime)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error starting two-factor login")
+		return true
+	}
+
+	respondWithJSON(w, http.StatusOK, MFAChallenge{MFARequired: true, ChallengeToken: challenge})
+	return true
+}
@@ -0,0 +1,541 @@
+package main
+
+import (
+	"bytes"
+	"chirpy/internal/auth"
+	"chirpy/internal/database"
+	"chirpy/internal/webhook"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Events developers can subscribe to. Chirp events are public and go to
+// every subscribed endpoint; user events only go to the endpoints of the
+// user they are about.
+const (
+	webhookChirpCreated = "chirp.created"
+	webhookChirpDeleted = "chirp.deleted"
+	webhookUserFollowed = "user.followed"
+	webhookUserUpgraded = "user.upgraded"
+)
+
+var webhookEvents = []string{webhookChirpCreated, webhookChirpDeleted, webhookUserFollowed, webhookUserUpgraded}
+
+const (
+	maxWebhookEndpoints = 10
+	maxWebhookURLLength = 2048
+
+	webhookSignatureHeader = "Chirpy-Signature"
+
+	// A delivery is tried webhookMaxAttempts times, waiting twice as long
+	// after each failure, from webhookRetryBase up to webhookRetryMax. After
+	// that it is dead until someone redelivers it.
+	webhookMaxAttempts = 8
+	webhookRetryBase   = time.Second * 30
+	webhookRetryMax    = time.Hour
+
+	webhookBatchSize = 10
+	webhookTimeout   = time.Second * 10
+	// webhookLease is how long a worker has to send a claimed delivery
+	// before another one may try it.
+	webhookLease = time.Minute
+
+	webhookDeliveryDelivered = "delivered"
+	webhookDeliveryFailed    = "failed"
+	webhookDeliveryDead      = "dead"
+)
+
+// webhookDispatcher sends queued deliveries. Handlers only queue them, so
+// a slow or broken endpoint never holds up the request that caused the
+// event.
+type webhookDispatcher struct {
+	client *http.Client
+	wake   chan struct{}
+	// allowInsecure lets endpoints use plain http and private addresses,
+	// for trying webhooks out against a local server.
+	allowInsecure bool
+}
+
+func newWebhookDispatcher(allowInsecure bool) *webhookDispatcher {
+	return &webhookDispatcher{
+		client:        webhook.NewClient(webhookTimeout, allowInsecure),
+		wake:          make(chan struct{}, 1),
+		allowInsecure: allowInsecure,
+	}
+}
+
+// notify tells the worker there may be deliveries to send now rather than
+// at its next poll.
+func (d *webhookDispatcher) notify() {
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// webhookPayload is the body of every delivery. ID identifies the event
+// and is the same in every delivery and redelivery of it.
+type webhookPayload struct {
+	ID        uuid.UUID `json:"id"`
+	Event     string    `json:"event"`
+	CreatedAt time.Time `json:"created_at"`
+	Data      any       `json:"data"`
+}
+
+// queueWebhookEvent queues event for the endpoints subscribed to it. An
+// event with an owner only goes to that user's endpoints. Pass the Queries
+// of a transaction to queue the event only if the transaction commits.
+func queueWebhookEvent(ctx context.Context, q *database.Queries, event string, owner uuid.NullUUID, data any) (int64, error) {
+	eventID := uuid.New()
+	payload, err := json.Marshal(webhookPayload{
+		ID:        eventID,
+		Event:     event,
+		CreatedAt: time.Now().UTC(),
+		Data:      data,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return q.EnqueueWebhookDeliveries(ctx, database.EnqueueWebhookDeliveriesParams{
+		EventID: eventID,
+		Event:   event,
+		Payload: string(payload),
+		OwnerID: owner,
+	})
+}
+
+// publishWebhookEvent queues event and wakes the worker. A failure is
+// logged rather than failing the request that caused the event.
+func (apiCfg *apiConfig) publishWebhookEvent(ctx context.Context, event string, owner uuid.NullUUID, data any) {
+	queued, err := queueWebhookEvent(ctx, apiCfg.DB, event, owner, data)
+	if err != nil {
+		log.Printf("Error queueing %s webhooks: %s", event, err)
+		return
+	}
+	if queued > 0 {
+		apiCfg.Webhooks.notify()
+	}
+}
+
+// runWebhookDeliveries sends due deliveries whenever it is woken and every
+// interval until ctx is done. Deliveries are claimed with SKIP LOCKED, so
+// it is safe to run on every instance.
+func (apiCfg *apiConfig) runWebhookDeliveries(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		for apiCfg.sendDueWebhooks(ctx) == webhookBatchSize {
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-apiCfg.Webhooks.wake:
+		}
+	}
+}
+
+// sendDueWebhooks sends one batch of due deliveries and returns how many
+// there were.
+func (apiCfg *apiConfig) sendDueWebhooks(ctx context.Context) int {
+	deliveries, err := apiCfg.DB.ClaimWebhookDeliveries(ctx, database.ClaimWebhookDeliveriesParams{
+		LeaseUntil: sql.NullTime{Time: time.Now().Add(webhookLease), Valid: true},
+		Limit:      webhookBatchSize,
+	})
+	if err != nil {
+		log.Printf("Error claiming webhook deliveries: %s", err)
+		return 0
+	}
+
+	var wg sync.WaitGroup
+	for _, delivery := range deliveries {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			apiCfg.sendWebhook(ctx, delivery)
+		}()
+	}
+	wg.Wait()
+	return len(deliveries)
+}
+
+func (apiCfg *apiConfig) sendWebhook(ctx context.Context, delivery database.WebhookDelivery) {
+	endpoint, err := apiCfg.DB.GetWebhookEndpointByID(ctx, delivery.EndpointID)
+	if errors.Is(err, sql.ErrNoRows) {
+		// The endpoint was deleted, and its deliveries with it.
+		return
+	}
+	if err != nil {
+		log.Printf("Error loading webhook endpoint %s: %s", delivery.EndpointID, err)
+		return
+	}
+
+	status, err := apiCfg.Webhooks.post(ctx, endpoint, delivery)
+	attempt := database.RecordWebhookAttemptParams{
+		ID:             delivery.ID,
+		Status:         webhookDeliveryDelivered,
+		ResponseStatus: sql.NullInt32{Int32: int32(status), Valid: status != 0},
+	}
+	if err != nil {
+		attempt.LastError = sql.NullString{String: err.Error(), Valid: true}
+		attempt.Status = webhookDeliveryFailed
+		if attempts := int(delivery.Attempts) + 1; attempts < webhookMaxAttempts {
+			attempt.NextAttemptAt = sql.NullTime{Time: time.Now().Add(webhookRetryDelay(attempts)), Valid: true}
+		} else {
+			attempt.Status = webhookDeliveryDead
+		}
+	}
+
+	if err := apiCfg.DB.RecordWebhookAttempt(ctx, attempt); err != nil {
+		log.Printf("Error recording webhook delivery %s: %s", delivery.ID, err)
+	}
+}
+
+// webhookRetryDelay is how long to wait after the given number of failed
+// attempts.
+func webhookRetryDelay(attempts int) time.Duration {
+	delay := webhookRetryBase
+	for i := 1; i < attempts && delay < webhookRetryMax; i++ {
+		delay *= 2
+	}
+	return min(delay, webhookRetryMax)
+}
+
+// post sends one delivery, signed with the endpoint's secret at the time
+// of sending. It returns the response status, or 0 if there was none, and
+// an error unless the endpoint answered with a 2xx.
+func (d *webhookDispatcher) post(ctx context.Context, endpoint database.WebhookEndpoint, delivery database.WebhookDelivery) (int, error) {
+	body := []byte(delivery.Payload)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint.Url, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Chirpy-Webhooks/1.0")
+	req.Header.Set("Chirpy-Event", delivery.Event)
+	req.Header.Set("Chirpy-Delivery", delivery.ID.String())
+	req.Header.Set(webhookSignatureHeader, webhook.Sign(endpoint.Secret, time.Now(), body))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("endpoint responded with %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+type WebhookEndpoint struct {
+	ID        uuid.UUID `json:"id"`
+	URL       string    `json:"url"`
+	Events    []string  `json:"events"`
+	CreatedAt time.Time `json:"created_at"`
+	// Secret is only set in the response to registering the endpoint.
+	Secret string `json:"secret,omitempty"`
+}
+
+type WebhookDelivery struct {
+	ID             uuid.UUID       `json:"id"`
+	CreatedAt      time.Time       `json:"created_at"`
+	Event          string          `json:"event"`
+	Status         string          `json:"status"`
+	Attempts       int32           `json:"attempts"`
+	NextAttemptAt  *time.Time      `json:"next_attempt_at"`
+	LastAttemptAt  *time.Time      `json:"last_attempt_at"`
+	ResponseStatus *int32          `json:"response_status"`
+	LastError      *string         `json:"last_error"`
+	DeliveredAt    *time.Time      `json:"delivered_at"`
+	Payload        json.RawMessage `json:"payload"`
+}
+
+func databaseEndpointToWebhookEndpoint(endpoint database.WebhookEndpoint) WebhookEndpoint {
+	return WebhookEndpoint{
+		ID:        endpoint.ID,
+		URL:       endpoint.Url,
+		Events:    endpoint.Events,
+		CreatedAt: endpoint.CreatedAt,
+	}
+}
+
+func databaseDeliveryToWebhookDelivery(delivery database.WebhookDelivery) WebhookDelivery {
+	response := WebhookDelivery{
+		ID:            delivery.ID,
+		CreatedAt:     delivery.CreatedAt,
+		Event:         delivery.Event,
+		Status:        delivery.Status,
+		Attempts:      delivery.Attempts,
+		NextAttemptAt: nullTimePointer(delivery.NextAttemptAt),
+		LastAttemptAt: nullTimePointer(delivery.LastAttemptAt),
+		DeliveredAt:   nullTimePointer(delivery.DeliveredAt),
+		Payload:       json.RawMessage(delivery.Payload),
+	}
+	if delivery.ResponseStatus.Valid {
+		response.ResponseStatus = &delivery.ResponseStatus.Int32
+	}
+	if delivery.LastError.Valid {
+		response.LastError = &delivery.LastError.String
+	}
+	return response
+}
+
+func (d *webhookDispatcher) validateURL(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" || len(rawURL) > maxWebhookURLLength {
+		return errors.New("url must be an absolute URL")
+	}
+	if u.Scheme != "https" && !(d.allowInsecure && u.Scheme == "http") {
+		return errors.New("url must use https")
+	}
+	if u.User != nil {
+		return errors.New("url must not contain credentials")
+	}
+	return nil
+}
+
+func validateWebhookEvents(events []string) error {
+	if len(events) == 0 {
+		return errors.New("At least one event is required")
+	}
+	for _, event := range events {
+		if !slices.Contains(webhookEvents, event) {
+			return fmt.Errorf("Unknown event %q", event)
+		}
+	}
+	return nil
+}
+
+// handlerCreateWebhook registers an endpoint for the caller. Its signing
+// secret is only shown in this response.
+func (apiCfg *apiConfig) handlerCreateWebhook(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		URL    string   `json:"url"`
+		Events []string `json:"events"`
+	}
+
+	subject := authenticatedSubject(r)
+	if !requireScope(w, subject, auth.ScopeWebhooks) {
+		return
+	}
+
+	params := parameters{}
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+
+	if err := apiCfg.Webhooks.validateURL(params.URL); err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := validateWebhookEvents(params.Events); err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	slices.Sort(params.Events)
+	params.Events = slices.Compact(params.Events)
+
+	count, err := apiCfg.DB.CountWebhookEndpoints(r.Context(), subject.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Could not register webhook")
+		return
+	}
+	if count >= maxWebhookEndpoints {
+		respondWithError(w, http.StatusConflict, fmt.Sprintf("You can register at most %d webhook endpoints", maxWebhookEndpoints))
+		return
+	}
+
+	secret, err := auth.MakeRandomToken()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Could not register webhook")
+		return
+	}
+	secret = "whsec_" + secret
+
+	endpoint, err := apiCfg.DB.CreateWebhookEndpoint(r.Context(), database.CreateWebhookEndpointParams{
+		OwnerID: subject.UserID,
+		Url:     params.URL,
+		Secret:  secret,
+		Events:  params.Events,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Could not register webhook")
+		return
+	}
+
+	response := databaseEndpointToWebhookEndpoint(endpoint)
+	response.Secret = secret
+	respondWithJSON(w, http.StatusCreated, response)
+}
+
+func (apiCfg *apiConfig) handlerListWebhooks(w http.ResponseWriter, r *http.Request) {
+	subject := authenticatedSubject(r)
+	if !requireScope(w, subject, auth.ScopeWebhooks) {
+		return
+	}
+
+	endpoints, err := apiCfg.DB.ListWebhookEndpoints(r.Context(), subject.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Could not list webhooks")
+		return
+	}
+
+	response := []WebhookEndpoint{}
+	for _, endpoint := range endpoints {
+		response = append(response, databaseEndpointToWebhookEndpoint(endpoint))
+	}
+	respondWithJSON(w, http.StatusOK, response)
+}
+
+// handlerDeleteWebhook removes an endpoint along with its delivery log.
+func (apiCfg *apiConfig) handlerDeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	endpointID, err := uuid.Parse(r.PathValue("webhookID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid webhook ID")
+		return
+	}
+
+	subject := authenticatedSubject(r)
+	if !requireScope(w, subject, auth.ScopeWebhooks) {
+		return
+	}
+
+	rows, err := apiCfg.DB.DeleteWebhookEndpoint(r.Context(), database.DeleteWebhookEndpointParams{
+		ID:      endpointID,
+		OwnerID: subject.UserID,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Could not delete webhook")
+		return
+	}
+	if rows == 0 {
+		respondWithError(w, http.StatusNotFound, "Webhook not found")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// callerWebhookEndpoint loads the endpoint in the path if the caller owns
+// it. Otherwise it writes an error and returns false.
+func (apiCfg *apiConfig) callerWebhookEndpoint(w http.ResponseWriter, r *http.Request) (database.WebhookEndpoint, bool) {
+	endpointID, err := uuid.Parse(r.PathValue("webhookID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid webhook ID")
+		return database.WebhookEndpoint{}, false
+	}
+
+	subject := authenticatedSubject(r)
+	if !requireScope(w, subject, auth.ScopeWebhooks) {
+		return database.WebhookEndpoint{}, false
+	}
+
+	endpoint, err := apiCfg.DB.GetWebhookEndpoint(r.Context(), database.GetWebhookEndpointParams{
+		ID:      endpointID,
+		OwnerID: subject.UserID,
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, http.StatusNotFound, "Webhook not found")
+		return database.WebhookEndpoint{}, false
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Could not load webhook")
+		return database.WebhookEndpoint{}, false
+	}
+	return endpoint, true
+}
+
+// handlerListWebhookDeliveries is an endpoint's delivery log, newest first.
+func (apiCfg *apiConfig) handlerListWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	endpoint, ok := apiCfg.callerWebhookEndpoint(w, r)
+	if !ok {
+		return
+	}
+
+	limit := maxPageLimit
+	if raw := r.URL.Query().Get("limit"); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil || parsed < 1 || parsed > maxPageLimit {
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("limit must be between 1 and %d", maxPageLimit))
+			return
+		}
+		limit = parsed
+	}
+
+	deliveries, err := apiCfg.DB.ListWebhookDeliveries(r.Context(), database.ListWebhookDeliveriesParams{
+		EndpointID: endpoint.ID,
+		Limit:      int32(limit),
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Could not list deliveries")
+		return
+	}
+
+	response := []WebhookDelivery{}
+	for _, delivery := range deliveries {
+		response = append(response, databaseDeliveryToWebhookDelivery(delivery))
+	}
+	respondWithJSON(w, http.StatusOK, response)
+}
+
+// handlerRedeliverWebhook sends a delivery again with a fresh set of
+// retries. Deliveries still waiting to be sent, or being sent, are refused
+// so they don't go out twice.
+func (apiCfg *apiConfig) handlerRedeliverWebhook(w http.ResponseWriter, r *http.Request) {
+	endpoint, ok := apiCfg.callerWebhookEndpoint(w, r)
+	if !ok {
+		return
+	}
+
+	deliveryID, err := uuid.Parse(r.PathValue("deliveryID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid delivery ID")
+		return
+	}
+
+	delivery, err := apiCfg.DB.RedeliverWebhookDelivery(r.Context(), database.RedeliverWebhookDeliveryParams{
+		ID:         deliveryID,
+		EndpointID: endpoint.ID,
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		_, err = apiCfg.DB.GetWebhookDelivery(r.Context(), database.GetWebhookDeliveryParams{
+			ID:         deliveryID,
+			EndpointID: endpoint.ID,
+		})
+		if errors.Is(err, sql.ErrNoRows) {
+			respondWithError(w, http.StatusNotFound, "Delivery not found")
+			return
+		}
+		if err == nil {
+			respondWithError(w, http.StatusConflict, "Delivery is still being sent")
+			return
+		}
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Could not redeliver")
+		return
+	}
+	apiCfg.Webhooks.notify()
+
+	respondWithJSON(w, http.StatusAccepted, databaseDeliveryToWebhookDelivery(delivery))
+}
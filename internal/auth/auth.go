@@ -2,70 +2,104 @@ package auth
 
 import (
 	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
 	"encoding/hex"
 	"errors"
-	"fmt"
 	"net/http"
 	"strings"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
-	"golang.org/x/crypto/bcrypt"
 )
 
-func HashPassword(password string) (string, error) {
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
-	if err != nil {
-		return "", err
-	}
-
-	return string(hashedPassword), nil
+// DefaultAccessTokenLifetime is used when MakeJWT is not given a lifetime.
+const DefaultAccessTokenLifetime = time.Hour
+
+// Claims are the claims carried by a Chirpy access token. SessionID ties
+// the token to the refresh token family it was issued from, if any, and
+// Scope lists what the token may be used for. ClientID is set on tokens
+// issued to an OAuth client. CSRFHash is set on tokens kept in a browser
+// cookie and binds them to the CSRF token issued alongside.
+type Claims struct {
+	jwt.RegisteredClaims
+	SessionID string `json:"sid,omitempty"`
+	Scope     string `json:"scope,omitempty"`
+	ClientID  string `json:"client_id,omitempty"`
+	CSRFHash  string `json:"csrf,omitempty"`
+	Purpose   string `json:"purpose,omitempty"`
+	Email     string `json:"email,omitempty"`
 }
 
-func CheckPasswordHash(password, hash string) error {
-	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
-	if err != nil {
-		return err
+func getClaims(userID, sessionID uuid.UUID, expiresIn time.Duration, scopes []string) *Claims {
+	if expiresIn <= 0 {
+		expiresIn = DefaultAccessTokenLifetime
 	}
 
-	return nil
+	now := time.Now()
+	claims := &Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(expiresIn)),
+			Subject:   userID.String(),
+		},
+		Scope: FormatScope(scopes),
+	}
+	if sessionID != uuid.Nil {
+		claims.SessionID = sessionID.String()
+	}
+
+	return claims
 }
 
-func getClaims(userID uuid.UUID) *jwt.RegisteredClaims {
-	const defaultExpiration = time.Hour
+// MakeJWT signs an access token for userID that expires after expiresIn, or
+// DefaultAccessTokenLifetime if expiresIn is zero.
+func MakeJWT(userID uuid.UUID, keys *Keyring, sessionID uuid.UUID, expiresIn time.Duration, scopes []string) (string, error) {
+	claims := getClaims(userID, sessionID, expiresIn, scopes)
 
-	claims := &jwt.RegisteredClaims{
-		Issuer:    "chirpy",
-		IssuedAt:  jwt.NewNumericDate(time.Now()),
-		ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
-		Subject:   userID.String(),
+	tokenString, err := keys.Sign(claims)
+	if err != nil {
+		return "", err
 	}
 
-	return claims
+	return tokenString, nil
 }
 
-func MakeJWT(userID uuid.UUID, tokenSecret string) (string, error) {
-	claims := getClaims(userID)
-
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+// MakeCookieJWT signs an access token for a browser session that keeps it in
+// a cookie. The token is only accepted together with csrfToken.
+func MakeCookieJWT(userID uuid.UUID, keys *Keyring, sessionID uuid.UUID, expiresIn time.Duration, scopes []string, csrfToken string) (string, error) {
+	claims := getClaims(userID, sessionID, expiresIn, scopes)
+	claims.CSRFHash = HashToken(csrfToken)
+	return keys.Sign(claims)
+}
 
-	fmt.Println("TOken", tokenSecret)
+// CheckCSRFToken reports whether csrfToken is the one claims were issued
+// with.
+func CheckCSRFToken(claims *Claims, csrfToken string) bool {
+	if claims.CSRFHash == "" || csrfToken == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(claims.CSRFHash), []byte(HashToken(csrfToken))) == 1
+}
 
-	tokenString, err := token.SignedString([]byte(tokenSecret))
+// ParseJWT validates an access token and returns its claims. Tokens minted
+// for another purpose, such as email verification, are rejected.
+func ParseJWT(tokenString string, keys *Keyring) (*Claims, error) {
+	claims, err := keys.Parse(tokenString)
 	if err != nil {
-		return "", nil
+		return nil, err
+	}
+	if claims.Purpose != "" {
+		return nil, errors.New("not an access token")
 	}
 
-	return tokenString, nil
+	return claims, nil
 }
 
-func ValidateJWT(tokenString, tokenSecret string) (uuid.UUID, error) {
-	claims := &jwt.RegisteredClaims{}
-	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-		return []byte(tokenSecret), nil
-	})
-	if err != nil || !token.Valid {
+func ValidateJWT(tokenString string, keys *Keyring) (uuid.UUID, error) {
+	claims, err := ParseJWT(tokenString, keys)
+	if err != nil {
 		return uuid.Nil, err
 	}
 
@@ -77,6 +111,18 @@ func ValidateJWT(tokenString, tokenSecret string) (uuid.UUID, error) {
 	return userID, nil
 }
 
+// SessionIDFromClaims returns the session the token was issued for, or
+// uuid.Nil if it is not tied to one.
+func SessionIDFromClaims(claims *Claims) uuid.UUID {
+	sessionID, err := uuid.Parse(claims.SessionID)
+	if err != nil {
+		return uuid.Nil
+	}
+	return sessionID
+}
+
+// GetBearerToken extracts the token from an "Authorization: Bearer <token>"
+// header. The scheme is matched case-insensitively, as RFC 7235 requires.
 func GetBearerToken(headers http.Header) (string, error) {
 	header := headers.Get("Authorization")
 
@@ -84,20 +130,29 @@ func GetBearerToken(headers http.Header) (string, error) {
 		return "", errors.New("no bearer token provided")
 	}
 
-	if !strings.HasPrefix(header, "bearer ") {
+	scheme, token, ok := strings.Cut(header, " ")
+	if !ok || !strings.EqualFold(scheme, "bearer") {
 		return "", errors.New("invalid bearer token format")
 	}
 
-	token := strings.TrimSpace(header[len("bearer "):])
+	token = strings.TrimSpace(token)
+	if token == "" {
+		return "", errors.New("invalid bearer token format")
+	}
 
 	return token, nil
 }
 
 func MakeRefreshToken() (string, error) {
+	return MakeRandomToken()
+}
+
+// MakeRandomToken returns 256 bits of randomness, hex encoded. It is used
+// for every opaque credential Chirpy hands out.
+func MakeRandomToken() (string, error) {
 	key := make([]byte, 32)
 	_, err := rand.Read(key)
 	if err != nil {
-		fmt.Println(err)
 		return "", err
 	}
 
@@ -105,6 +160,36 @@ func MakeRefreshToken() (string, error) {
 	return token, nil
 }
 
+// PersonalAccessTokenPrefix starts every personal access token, which tells
+// them apart from JWTs and makes leaked tokens easy to scan for.
+const PersonalAccessTokenPrefix = "chirpy_pat_"
+
+func MakePersonalAccessToken() (string, error) {
+	token, err := MakeRandomToken()
+	if err != nil {
+		return "", err
+	}
+	return PersonalAccessTokenPrefix + token, nil
+}
+
+func IsPersonalAccessToken(token string) bool {
+	return strings.HasPrefix(token, PersonalAccessTokenPrefix)
+}
+
+// HashRefreshToken returns the form of a refresh token that is stored in the
+// database.
+func HashRefreshToken(token string) string {
+	return HashToken(token)
+}
+
+// HashToken hashes a token from MakeRandomToken for storage. The tokens
+// carry 256 bits of randomness, so a plain SHA-256 is enough and keeps
+// lookups by hash cheap.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
 func GetAPIKey(headers http.Header) (string, error) {
 	header := headers.Get("Authorization")
 
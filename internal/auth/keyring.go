@@ -0,0 +1,314 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// SigningKey is one key in a Keyring. Symmetric keys verify and sign with
+// the same secret and are never published; asymmetric keys publish their
+// public half in the JWKS document.
+type SigningKey struct {
+	ID     string
+	Method jwt.SigningMethod
+	// signKey is a []byte for HS256 and a crypto.Signer otherwise.
+	signKey   any
+	verifyKey any
+	// RetiredAt is set on keys that no longer sign. They keep verifying
+	// tokens until the keyring's grace period after it has passed.
+	RetiredAt time.Time
+}
+
+// NewHMACKey wraps a shared HS256 secret. Its key ID is derived from the
+// secret so tokens signed before a restart keep resolving to it.
+func NewHMACKey(secret string) (*SigningKey, error) {
+	if secret == "" {
+		return nil, errors.New("HMAC secret is empty")
+	}
+	sum := sha256.Sum256([]byte(secret))
+	return &SigningKey{
+		ID:        "hs256-" + hex.EncodeToString(sum[:8]),
+		Method:    jwt.SigningMethodHS256,
+		signKey:   []byte(secret),
+		verifyKey: []byte(secret),
+	}, nil
+}
+
+// NewPrivateKey wraps an Ed25519 or RSA private key. The key ID is its RFC
+// 7638 JWK thumbprint.
+func NewPrivateKey(key crypto.Signer) (*SigningKey, error) {
+	k := &SigningKey{signKey: key, verifyKey: key.Public()}
+	switch key.(type) {
+	case ed25519.PrivateKey:
+		k.Method = jwt.SigningMethodEdDSA
+	case *rsa.PrivateKey:
+		k.Method = jwt.SigningMethodRS256
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", key)
+	}
+
+	thumbprint, err := k.thumbprint()
+	if err != nil {
+		return nil, err
+	}
+	k.ID = thumbprint
+	return k, nil
+}
+
+// LoadPrivateKeyFile reads a PEM encoded PKCS#8 (or PKCS#1 RSA) private key.
+func LoadPrivateKeyFile(path string) (*SigningKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s: no PEM data found", path)
+	}
+
+	var parsed any
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	default:
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	signer, ok := parsed.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("%s: unsupported key type %T", path, parsed)
+	}
+	key, err := NewPrivateKey(signer)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return key, nil
+}
+
+func (k *SigningKey) symmetric() bool {
+	_, ok := k.verifyKey.([]byte)
+	return ok
+}
+
+// JWK is the public form of a key in the JWKS document.
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+}
+
+func (k *SigningKey) jwk() (JWK, error) {
+	b64 := base64.RawURLEncoding.EncodeToString
+	switch pub := k.verifyKey.(type) {
+	case ed25519.PublicKey:
+		return JWK{KeyType: "OKP", KeyID: k.ID, Use: "sig", Algorithm: k.Method.Alg(), Curve: "Ed25519", X: b64(pub)}, nil
+	case *rsa.PublicKey:
+		e := big.NewInt(int64(pub.E)).Bytes()
+		return JWK{KeyType: "RSA", KeyID: k.ID, Use: "sig", Algorithm: k.Method.Alg(), N: b64(pub.N.Bytes()), E: b64(e)}, nil
+	default:
+		return JWK{}, errors.New("key has no public form")
+	}
+}
+
+// thumbprint hashes the required JWK members in lexicographic order, as
+// RFC 7638 specifies.
+func (k *SigningKey) thumbprint() (string, error) {
+	jwk, err := k.jwk()
+	if err != nil {
+		return "", err
+	}
+
+	var members any
+	switch jwk.KeyType {
+	case "OKP":
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+		}{jwk.Curve, jwk.KeyType, jwk.X}
+	case "RSA":
+		members = struct {
+			E   string `json:"e"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+		}{jwk.E, jwk.KeyType, jwk.N}
+	}
+
+	data, err := json.Marshal(members)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
+
+// Keyring holds every key Chirpy signs or verifies tokens with, together
+// with the issuer and audience its tokens carry. The first active key signs
+// new tokens; every active key, and every retired key still inside the grace
+// period, verifies them. Leeway is the clock skew allowed when checking a
+// token's time claims.
+type Keyring struct {
+	Issuer      string
+	Audience    string
+	GracePeriod time.Duration
+	Leeway      time.Duration
+
+	mu   sync.RWMutex
+	keys []*SigningKey
+}
+
+func NewKeyring(issuer, audience string, gracePeriod time.Duration) *Keyring {
+	return &Keyring{Issuer: issuer, Audience: audience, GracePeriod: gracePeriod}
+}
+
+// Add puts key on the ring. Keys added first are preferred for signing.
+func (kr *Keyring) Add(key *SigningKey) error {
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+
+	for _, existing := range kr.keys {
+		if existing.ID == key.ID {
+			return fmt.Errorf("duplicate key ID %q", key.ID)
+		}
+	}
+	kr.keys = append(kr.keys, key)
+	return nil
+}
+
+// Retire stops the key with the given ID from signing new tokens. Tokens it
+// has already signed stay valid for the grace period.
+func (kr *Keyring) Retire(kid string, at time.Time) error {
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+
+	for _, key := range kr.keys {
+		if key.ID == kid {
+			key.RetiredAt = at
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown key ID %q", kid)
+}
+
+func (kr *Keyring) usable(key *SigningKey, now time.Time) bool {
+	return key.RetiredAt.IsZero() || now.Before(key.RetiredAt.Add(kr.GracePeriod))
+}
+
+func (kr *Keyring) signingKey() (*SigningKey, error) {
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+
+	for _, key := range kr.keys {
+		if key.RetiredAt.IsZero() {
+			return key, nil
+		}
+	}
+	return nil, errors.New("keyring has no active signing key")
+}
+
+// lookup finds the key a token names in its kid header. Every token the
+// ring signs has one; tokens from before it existed are refused.
+func (kr *Keyring) lookup(token *jwt.Token) (any, error) {
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" {
+		return nil, errors.New("token has no key ID")
+	}
+	now := time.Now()
+	for _, key := range kr.keys {
+		if key.ID != kid {
+			continue
+		}
+		if !kr.usable(key, now) {
+			return nil, errors.New("signing key has been retired")
+		}
+		// Never let a token pick the algorithm a key is used with.
+		if token.Method.Alg() != key.Method.Alg() {
+			return nil, errors.New("unexpected signing method")
+		}
+		return key.verifyKey, nil
+	}
+	return nil, errors.New("unknown signing key")
+}
+
+// Sign signs claims with the current signing key and stamps the issuer and
+// audience.
+func (kr *Keyring) Sign(claims *Claims) (string, error) {
+	key, err := kr.signingKey()
+	if err != nil {
+		return "", err
+	}
+
+	claims.Issuer = kr.Issuer
+	claims.Audience = jwt.ClaimStrings{kr.Audience}
+
+	token := jwt.NewWithClaims(key.Method, claims)
+	token.Header["kid"] = key.ID
+	return token.SignedString(key.signKey)
+}
+
+// Parse verifies a token against the ring, including its issuer and
+// audience, and returns its claims.
+func (kr *Keyring) Parse(tokenString string) (*Claims, error) {
+	claims := &Claims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, kr.lookup,
+		jwt.WithValidMethods([]string{"HS256", "RS256", "EdDSA"}),
+		jwt.WithIssuer(kr.Issuer),
+		jwt.WithAudience(kr.Audience),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(kr.Leeway),
+	)
+	if err != nil {
+		return nil, err
+	}
+	if !token.Valid {
+		return nil, errors.New("invalid token")
+	}
+
+	return claims, nil
+}
+
+// JWKS returns the public keys other services may verify tokens with.
+// Shared secrets are never included.
+func (kr *Keyring) JWKS() []JWK {
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+
+	keys := []JWK{}
+	now := time.Now()
+	for _, key := range kr.keys {
+		if key.symmetric() || !kr.usable(key, now) {
+			continue
+		}
+		if jwk, err := key.jwk(); err == nil {
+			keys = append(keys, jwk)
+		}
+	}
+	return keys
+}
@@ -0,0 +1,100 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ThirdPartyScopes are the scopes an OAuth client may be granted. The
+// account scope never leaves first-party logins, so no third-party app can
+// change a user's password or mint tokens in their name.
+var ThirdPartyScopes = []string{ScopeChirpsRead, ScopeChirpsWrite, ScopeProfileWrite, ScopeWebhooks}
+
+// codeVerifierPattern is the character set and length RFC 7636 allows for
+// a PKCE code verifier.
+var codeVerifierPattern = regexp.MustCompile(`^[A-Za-z0-9\-._~]{43,128}$`)
+
+// PKCEChallenge returns the S256 code challenge for verifier.
+func PKCEChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// VerifyPKCE checks a code verifier against the S256 challenge the client
+// sent when it asked for the authorization code.
+func VerifyPKCE(verifier, challenge string) bool {
+	if !codeVerifierPattern.MatchString(verifier) {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(PKCEChallenge(verifier)), []byte(challenge)) == 1
+}
+
+// ValidatePKCEChallenge checks that challenge could be the S256 hash of a
+// code verifier.
+func ValidatePKCEChallenge(challenge, method string) error {
+	if method != "S256" {
+		return errors.New("code_challenge_method must be S256")
+	}
+	decoded, err := base64.RawURLEncoding.DecodeString(challenge)
+	if err != nil || len(decoded) != sha256.Size {
+		return errors.New("code_challenge must be a base64url encoded SHA-256 hash")
+	}
+	return nil
+}
+
+// ValidateRedirectURI checks that uri is an absolute URI without a fragment.
+// Plain http is only accepted for loopback addresses, for native apps.
+func ValidateRedirectURI(uri string) error {
+	u, err := url.Parse(uri)
+	if err != nil || !u.IsAbs() || u.Host == "" {
+		return fmt.Errorf("redirect URI %q must be an absolute URL", uri)
+	}
+	if u.Fragment != "" {
+		return fmt.Errorf("redirect URI %q must not contain a fragment", uri)
+	}
+	if u.Scheme != "https" && !(u.Scheme == "http" && slices.Contains([]string{"localhost", "127.0.0.1", "[::1]"}, u.Hostname())) {
+		return fmt.Errorf("redirect URI %q must use https", uri)
+	}
+	return nil
+}
+
+// ValidateClientScopes checks that scopes is a non-empty list of scopes an
+// OAuth client may be granted.
+func ValidateClientScopes(scopes []string) error {
+	if len(scopes) == 0 {
+		return errors.New("at least one scope is required")
+	}
+	for _, scope := range scopes {
+		if !slices.Contains(ThirdPartyScopes, scope) {
+			return fmt.Errorf("scope %q cannot be granted to OAuth clients", scope)
+		}
+	}
+	return nil
+}
+
+// MakeClientJWT signs an access token issued to an OAuth client on behalf
+// of userID. It is an ordinary access token with the client's ID attached.
+func MakeClientJWT(userID uuid.UUID, keys *Keyring, sessionID, clientID uuid.UUID, expiresIn time.Duration, scopes []string) (string, error) {
+	claims := getClaims(userID, sessionID, expiresIn, scopes)
+	claims.ClientID = clientID.String()
+	return keys.Sign(claims)
+}
+
+// ClientIDFromClaims returns the OAuth client a token was issued to, or
+// uuid.Nil for a first-party token.
+func ClientIDFromClaims(claims *Claims) uuid.UUID {
+	clientID, err := uuid.Parse(claims.ClientID)
+	if err != nil {
+		return uuid.Nil
+	}
+	return clientID
+}
@@ -0,0 +1,64 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+)
+
+// RFC 7636 Appendix B.
+const (
+	rfcVerifier  = "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	rfcChallenge = "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"
+)
+
+func TestPKCEChallenge(t *testing.T) {
+	if got := PKCEChallenge(rfcVerifier); got != rfcChallenge {
+		t.Errorf("PKCEChallenge = %s, want %s", got, rfcChallenge)
+	}
+}
+
+func TestVerifyPKCE(t *testing.T) {
+	tests := []struct {
+		name      string
+		verifier  string
+		challenge string
+		ok        bool
+	}{
+		{"rfc vector", rfcVerifier, rfcChallenge, true},
+		{"wrong verifier", strings.Repeat("a", 43), rfcChallenge, false},
+		{"wrong challenge", rfcVerifier, PKCEChallenge(strings.Repeat("a", 43)), false},
+		{"verifier too short", "short", PKCEChallenge("short"), false},
+		{"verifier too long", strings.Repeat("a", 129), PKCEChallenge(strings.Repeat("a", 129)), false},
+		{"verifier with bad characters", strings.Repeat("a", 42) + "+", PKCEChallenge(strings.Repeat("a", 42) + "+"), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := VerifyPKCE(tt.verifier, tt.challenge); got != tt.ok {
+				t.Errorf("VerifyPKCE = %v, want %v", got, tt.ok)
+			}
+		})
+	}
+}
+
+func TestValidatePKCEChallenge(t *testing.T) {
+	tests := []struct {
+		name      string
+		challenge string
+		method    string
+		ok        bool
+	}{
+		{"rfc vector", rfcChallenge, "S256", true},
+		{"plain method", rfcChallenge, "plain", false},
+		{"padded", rfcChallenge + "=", "S256", false},
+		{"too short", rfcChallenge[:40], "S256", false},
+		{"not base64url", strings.Repeat("+", 43), "S256", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidatePKCEChallenge(tt.challenge, tt.method)
+			if (err == nil) != tt.ok {
+				t.Errorf("ValidatePKCEChallenge = %v, want ok %v", err, tt.ok)
+			}
+		})
+	}
+}
@@ -0,0 +1,82 @@
+package auth
+
+import (
+	"bufio"
+	_ "embed"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode/utf8"
+)
+
+// MaxPasswordLength bounds the work a single hash can cost.
+const MaxPasswordLength = 256
+
+//go:embed breached_passwords.txt
+var breachedPasswords string
+
+// PasswordPolicy decides which new passwords are acceptable: long enough,
+// not absurdly long, and not on a list of passwords known from breaches.
+type PasswordPolicy struct {
+	MinLength int
+	breached  map[string]struct{}
+}
+
+// NewPasswordPolicy returns a policy with the built-in breached-password
+// list loaded.
+func NewPasswordPolicy(minLength int) *PasswordPolicy {
+	policy := &PasswordPolicy{MinLength: minLength, breached: map[string]struct{}{}}
+	policy.addBreached(strings.NewReader(breachedPasswords))
+	return policy
+}
+
+// LoadBreachedPasswords adds the passwords in the file at path, one per
+// line, to the breached list.
+func (p *PasswordPolicy) LoadBreachedPasswords(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err := p.addBreached(f); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
+
+func (p *PasswordPolicy) addBreached(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		p.breached[strings.ToLower(line)] = struct{}{}
+	}
+	return scanner.Err()
+}
+
+// Check returns an error describing why password may not be used, or nil.
+// email is the account's address, which is never an acceptable password.
+func (p *PasswordPolicy) Check(password, email string) error {
+	length := utf8.RuneCountInString(password)
+	if length < p.MinLength {
+		return fmt.Errorf("Password must be at least %d characters long", p.MinLength)
+	}
+	if length > MaxPasswordLength {
+		return fmt.Errorf("Password must be at most %d characters long", MaxPasswordLength)
+	}
+
+	lower := strings.ToLower(password)
+	if _, ok := p.breached[lower]; ok {
+		return errors.New("Password is too common, it appears in known data breaches")
+	}
+	if email != "" && lower == strings.ToLower(email) {
+		return errors.New("Password must not be your email address")
+	}
+
+	return nil
+}
@@ -0,0 +1,114 @@
+package auth
+
+import (
+	"errors"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// testArgon2Params keep the tests fast; the format is the same at any cost.
+var testArgon2Params = Argon2Params{
+	Memory:      1024,
+	Iterations:  1,
+	Parallelism: 1,
+	SaltLength:  16,
+	KeyLength:   32,
+}
+
+func TestCheckPasswordHash(t *testing.T) {
+	argon, err := HashPassword("correct horse", testArgon2Params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacy, err := bcrypt.GenerateFromPassword([]byte("correct horse"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		password string
+		hash     string
+		want     error
+	}{
+		{"argon2id", "correct horse", argon, nil},
+		{"argon2id wrong password", "battery staple", argon, ErrPasswordMismatch},
+		{"bcrypt", "correct horse", string(legacy), nil},
+		{"bcrypt wrong password", "battery staple", string(legacy), ErrPasswordMismatch},
+		{"unknown format", "correct horse", "plaintext", errUnknownHash},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := CheckPasswordHash(tt.password, tt.hash); !errors.Is(err, tt.want) {
+				t.Errorf("CheckPasswordHash = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeArgon2Hash(t *testing.T) {
+	// RFC 9106 style PHC string, salt "somesalt".
+	const hash = "$argon2id$v=19$m=65536,t=3,p=4$c29tZXNhbHQ$iekCn0Y3spW+sCcFanM2xBT63UP2sghkUoHLIUpWRS8"
+
+	params, salt, key, err := decodeArgon2Hash(hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Argon2Params{Memory: 65536, Iterations: 3, Parallelism: 4, SaltLength: 8, KeyLength: 32}
+	if params != want {
+		t.Errorf("params = %+v, want %+v", params, want)
+	}
+	if string(salt) != "somesalt" {
+		t.Errorf("salt = %q, want %q", salt, "somesalt")
+	}
+	if len(key) != 32 {
+		t.Errorf("key is %d bytes, want 32", len(key))
+	}
+
+	for _, bad := range []string{
+		"",
+		"$argon2i$v=19$m=65536,t=3,p=4$c29tZXNhbHQ$iekCn0Y3spW+sCcFanM2xBT63UP2sghkUoHLIUpWRS8",
+		"$argon2id$v=16$m=65536,t=3,p=4$c29tZXNhbHQ$iekCn0Y3spW+sCcFanM2xBT63UP2sghkUoHLIUpWRS8",
+		"$argon2id$v=19$m=65536,p=4$c29tZXNhbHQ$iekCn0Y3spW+sCcFanM2xBT63UP2sghkUoHLIUpWRS8",
+		"$argon2id$v=19$m=65536,t=3,p=4$c29tZXNhbHQ=$iekCn0Y3spW+sCcFanM2xBT63UP2sghkUoHLIUpWRS8",
+		"$argon2id$v=19$m=65536,t=3,p=4$c29tZXNhbHQ$not*base64",
+		"$argon2id$v=19$m=65536,t=3,p=4$c29tZXNhbHQ",
+	} {
+		if _, _, _, err := decodeArgon2Hash(bad); !errors.Is(err, errUnknownHash) {
+			t.Errorf("decodeArgon2Hash(%q) = %v, want %v", bad, err, errUnknownHash)
+		}
+	}
+}
+
+func TestNeedsRehash(t *testing.T) {
+	hash, err := HashPassword("correct horse", testArgon2Params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacy, err := bcrypt.GenerateFromPassword([]byte("correct horse"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stronger := testArgon2Params
+	stronger.Iterations++
+
+	tests := []struct {
+		name   string
+		hash   string
+		params Argon2Params
+		want   bool
+	}{
+		{"same params", hash, testArgon2Params, false},
+		{"new params", hash, stronger, true},
+		{"bcrypt", string(legacy), testArgon2Params, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := NeedsRehash(tt.hash, tt.params); got != tt.want {
+				t.Errorf("NeedsRehash = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,59 @@
+package auth
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Scopes limit what an access token may be used for. They travel in the
+// space-delimited "scope" claim, as in RFC 8693. ScopeAccount covers the
+// account itself: email, password, sessions, 2FA and access tokens.
+const (
+	ScopeChirpsRead   = "chirps:read"
+	ScopeChirpsWrite  = "chirps:write"
+	ScopeProfileWrite = "profile:write"
+	ScopeWebhooks     = "webhooks"
+	ScopeAccount      = "account"
+)
+
+// DefaultScopes are granted to tokens from a password login, which can do
+// anything the user can.
+var DefaultScopes = []string{ScopeChirpsRead, ScopeChirpsWrite, ScopeProfileWrite, ScopeWebhooks, ScopeAccount}
+
+// GrantableScopes are the scopes a personal access token may carry. The
+// account scope is left out, so a leaked token can't be used to change the
+// password, turn off 2FA or mint more tokens.
+var GrantableScopes = []string{ScopeChirpsRead, ScopeChirpsWrite, ScopeProfileWrite, ScopeWebhooks}
+
+// ValidateScopes checks that scopes is a non-empty list of scopes a
+// personal access token may be granted.
+func ValidateScopes(scopes []string) error {
+	if len(scopes) == 0 {
+		return fmt.Errorf("at least one scope is required")
+	}
+	for _, scope := range scopes {
+		if !slices.Contains(GrantableScopes, scope) {
+			if slices.Contains(DefaultScopes, scope) {
+				return fmt.Errorf("scope %q cannot be granted to access tokens", scope)
+			}
+			return fmt.Errorf("unknown scope %q", scope)
+		}
+	}
+	return nil
+}
+
+// FormatScope joins scopes into the form stored in the scope claim.
+func FormatScope(scopes []string) string {
+	return strings.Join(scopes, " ")
+}
+
+// ParseScope splits a scope claim into its scopes.
+func ParseScope(scope string) []string {
+	return strings.Fields(scope)
+}
+
+// HasScope reports whether the token was granted scope.
+func (c *Claims) HasScope(scope string) bool {
+	return slices.Contains(ParseScope(c.Scope), scope)
+}
@@ -0,0 +1,74 @@
+package auth
+
+import (
+	"errors"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+// Purposes of the single-use tokens that are signed like access tokens but
+// must never be accepted as one.
+const (
+	PurposeEmailVerification = "email_verification"
+	PurposeMFAChallenge      = "mfa_challenge"
+)
+
+func makePurposeToken(userID uuid.UUID, purpose, email string, keys *Keyring, expiresIn time.Duration) (string, error) {
+	claims := &Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(expiresIn)),
+			Subject:   userID.String(),
+		},
+		Purpose: purpose,
+		Email:   email,
+	}
+
+	return keys.Sign(claims)
+}
+
+func parsePurposeToken(tokenString string, keys *Keyring, purpose string) (*Claims, uuid.UUID, error) {
+	claims, err := keys.Parse(tokenString)
+	if err != nil {
+		return nil, uuid.Nil, err
+	}
+	if claims.Purpose != purpose {
+		return nil, uuid.Nil, errors.New("token was issued for another purpose")
+	}
+
+	userID, err := uuid.Parse(claims.Subject)
+	if err != nil {
+		return nil, uuid.Nil, err
+	}
+
+	return claims, userID, nil
+}
+
+// MakeEmailVerificationToken signs a token proving that whoever holds it
+// received mail at email. It stops working once the user changes address.
+func MakeEmailVerificationToken(userID uuid.UUID, email string, keys *Keyring, expiresIn time.Duration) (string, error) {
+	return makePurposeToken(userID, PurposeEmailVerification, email, keys, expiresIn)
+}
+
+// ValidateEmailVerificationToken returns the user and email address a
+// verification token was issued for.
+func ValidateEmailVerificationToken(tokenString string, keys *Keyring) (uuid.UUID, string, error) {
+	claims, userID, err := parsePurposeToken(tokenString, keys, PurposeEmailVerification)
+	if err != nil {
+		return uuid.Nil, "", err
+	}
+	return userID, claims.Email, nil
+}
+
+// MakeMFAChallengeToken signs the token handed out after a correct password
+// when the account still needs a second factor.
+func MakeMFAChallengeToken(userID uuid.UUID, keys *Keyring, expiresIn time.Duration) (string, error) {
+	return makePurposeToken(userID, PurposeMFAChallenge, "", keys, expiresIn)
+}
+
+func ValidateMFAChallengeToken(tokenString string, keys *Keyring) (uuid.UUID, error) {
+	_, userID, err := parsePurposeToken(tokenString, keys, PurposeMFAChallenge)
+	return userID, err
+}
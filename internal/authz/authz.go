@@ -0,0 +1,137 @@
+package authz
+
+import (
+	"chirpy/internal/auth"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type Role string
+
+const (
+	RoleUser      Role = "user"
+	RoleModerator Role = "moderator"
+	RoleAdmin     Role = "admin"
+)
+
+func ParseRole(s string) (Role, error) {
+	switch Role(s) {
+	case RoleUser, RoleModerator, RoleAdmin:
+		return Role(s), nil
+	}
+	return "", fmt.Errorf("unknown role %q", s)
+}
+
+type Action string
+
+const (
+	ActionCreate      Action = "create"
+	ActionEdit        Action = "edit"
+	ActionDelete      Action = "delete"
+	ActionModerate    Action = "moderate"
+	ActionManageRoles Action = "manage roles of"
+)
+
+type ResourceKind string
+
+const (
+	ResourceChirp ResourceKind = "chirp"
+	ResourceUser  ResourceKind = "user"
+	// ResourcePlan is a plan definition. Only admins may change them.
+	ResourcePlan ResourceKind = "plan"
+)
+
+// Subject is the authenticated caller a decision is made for. SessionID is
+// the login session the caller's credential came from, if it has one, and
+// Scopes are the scopes that credential was granted. TokenID is set for a
+// personal access token, and ExpiresAt for a credential that expires.
+type Subject struct {
+	UserID    uuid.UUID
+	Role      Role
+	Verified  bool
+	SessionID uuid.UUID
+	TokenID   uuid.UUID
+	ExpiresAt time.Time
+	Scopes    []string
+}
+
+// HasScope reports whether the subject's credential was granted scope.
+func (s Subject) HasScope(scope string) bool {
+	return slices.Contains(s.Scopes, scope)
+}
+
+// Resource is the thing being acted on. OwnerID is the user who owns it;
+// for a user account that is the account itself.
+type Resource struct {
+	Kind    ResourceKind
+	OwnerID uuid.UUID
+}
+
+var ErrForbidden = errors.New("forbidden")
+
+// Authorize decides whether subject may perform action on resource. It
+// returns nil when the action is allowed and an error wrapping ErrForbidden
+// when it is not.
+func Authorize(subject Subject, action Action, resource Resource) error {
+	if scope := requiredScope(action, resource.Kind); !subject.HasScope(scope) {
+		return fmt.Errorf("%w: this token does not have the %s scope", ErrForbidden, scope)
+	}
+	if resource.Kind == ResourceChirp && action == ActionCreate && !subject.Verified {
+		return fmt.Errorf("%w: verify your email address before posting chirps", ErrForbidden)
+	}
+	if allowed(subject, action, resource) {
+		return nil
+	}
+	return fmt.Errorf("%w: you may not %s this %s", ErrForbidden, action, resource.Kind)
+}
+
+// requiredScope is the scope a credential needs before its owner's role is
+// even considered. Acting on chirps needs chirps:write and editing a profile
+// needs profile:write; anything beyond that needs the account scope.
+func requiredScope(action Action, kind ResourceKind) string {
+	switch {
+	case kind == ResourceChirp:
+		return auth.ScopeChirpsWrite
+	case kind == ResourceUser && (action == ActionEdit || action == ActionDelete):
+		return auth.ScopeProfileWrite
+	default:
+		return auth.ScopeAccount
+	}
+}
+
+func allowed(subject Subject, action Action, resource Resource) bool {
+	if subject.UserID == uuid.Nil {
+		return false
+	}
+	if subject.Role == RoleAdmin {
+		return true
+	}
+
+	owner := resource.OwnerID == subject.UserID
+	moderator := subject.Role == RoleModerator
+
+	switch resource.Kind {
+	case ResourceChirp:
+		switch action {
+		case ActionCreate, ActionEdit:
+			return owner
+		case ActionDelete:
+			return owner || moderator
+		case ActionModerate:
+			return moderator
+		}
+	case ResourceUser:
+		switch action {
+		case ActionEdit, ActionDelete:
+			return owner
+		case ActionModerate:
+			return moderator && !owner
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,111 @@
+package authz
+
+import (
+	"chirpy/internal/auth"
+	"errors"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestAuthorize(t *testing.T) {
+	alice, bob := uuid.New(), uuid.New()
+	allScopes := auth.DefaultScopes
+
+	subject := func(id uuid.UUID, role Role, scopes ...string) Subject {
+		if len(scopes) == 0 {
+			scopes = allScopes
+		}
+		return Subject{UserID: id, Role: role, Verified: true, Scopes: scopes}
+	}
+	unverified := subject(alice, RoleUser)
+	unverified.Verified = false
+	unverifiedAdmin := subject(alice, RoleAdmin)
+	unverifiedAdmin.Verified = false
+
+	aliceChirp := Resource{Kind: ResourceChirp, OwnerID: alice}
+	bobChirp := Resource{Kind: ResourceChirp, OwnerID: bob}
+	aliceAccount := Resource{Kind: ResourceUser, OwnerID: alice}
+	bobAccount := Resource{Kind: ResourceUser, OwnerID: bob}
+	plan := Resource{Kind: ResourcePlan}
+
+	tests := []struct {
+		name     string
+		subject  Subject
+		action   Action
+		resource Resource
+		allowed  bool
+	}{
+		// Chirps.
+		{"user creates own chirp", subject(alice, RoleUser), ActionCreate, aliceChirp, true},
+		{"unverified user creates chirp", unverified, ActionCreate, aliceChirp, false},
+		{"unverified admin creates chirp", unverifiedAdmin, ActionCreate, aliceChirp, false},
+		{"unverified user deletes own chirp", unverified, ActionDelete, aliceChirp, true},
+		{"user creates chirp as someone else", subject(alice, RoleUser), ActionCreate, bobChirp, false},
+		{"user edits own chirp", subject(alice, RoleUser), ActionEdit, aliceChirp, true},
+		{"user edits other's chirp", subject(alice, RoleUser), ActionEdit, bobChirp, false},
+		{"user deletes own chirp", subject(alice, RoleUser), ActionDelete, aliceChirp, true},
+		{"user deletes other's chirp", subject(alice, RoleUser), ActionDelete, bobChirp, false},
+		{"user moderates chirp", subject(alice, RoleUser), ActionModerate, bobChirp, false},
+		{"moderator edits other's chirp", subject(alice, RoleModerator), ActionEdit, bobChirp, false},
+		{"moderator deletes other's chirp", subject(alice, RoleModerator), ActionDelete, bobChirp, true},
+		{"moderator moderates chirp", subject(alice, RoleModerator), ActionModerate, bobChirp, true},
+		{"admin edits other's chirp", subject(alice, RoleAdmin), ActionEdit, bobChirp, true},
+		{"admin deletes other's chirp", subject(alice, RoleAdmin), ActionDelete, bobChirp, true},
+
+		// User accounts.
+		{"user edits own profile", subject(alice, RoleUser), ActionEdit, aliceAccount, true},
+		{"user edits other's profile", subject(alice, RoleUser), ActionEdit, bobAccount, false},
+		{"user deletes own account", subject(alice, RoleUser), ActionDelete, aliceAccount, true},
+		{"moderator edits other's profile", subject(alice, RoleModerator), ActionEdit, bobAccount, false},
+		{"moderator moderates user", subject(alice, RoleModerator), ActionModerate, bobAccount, true},
+		{"moderator moderates self", subject(alice, RoleModerator), ActionModerate, aliceAccount, false},
+		{"user manages own role", subject(alice, RoleUser), ActionManageRoles, aliceAccount, false},
+		{"moderator manages roles", subject(alice, RoleModerator), ActionManageRoles, bobAccount, false},
+		{"admin manages roles", subject(alice, RoleAdmin), ActionManageRoles, bobAccount, true},
+		{"admin deletes other's account", subject(alice, RoleAdmin), ActionDelete, bobAccount, true},
+
+		// Plans.
+		{"user edits plan", subject(alice, RoleUser), ActionEdit, plan, false},
+		{"moderator edits plan", subject(alice, RoleModerator), ActionEdit, plan, false},
+		{"admin edits plan", subject(alice, RoleAdmin), ActionEdit, plan, true},
+
+		// Scopes are checked before roles.
+		{"read-only token posts chirp", subject(alice, RoleUser, auth.ScopeChirpsRead), ActionCreate, aliceChirp, false},
+		{"chirps:write token posts chirp", subject(alice, RoleUser, auth.ScopeChirpsWrite), ActionCreate, aliceChirp, true},
+		{"chirps:write token edits profile", subject(alice, RoleUser, auth.ScopeChirpsWrite), ActionEdit, aliceAccount, false},
+		{"profile:write token edits profile", subject(alice, RoleUser, auth.ScopeProfileWrite), ActionEdit, aliceAccount, true},
+		{"profile:write token moderates user", subject(alice, RoleModerator, auth.ScopeProfileWrite), ActionModerate, bobAccount, false},
+		{"admin token without account scope manages roles", subject(alice, RoleAdmin, auth.ScopeChirpsWrite, auth.ScopeProfileWrite), ActionManageRoles, bobAccount, false},
+		{"admin token without chirps:write deletes chirp", subject(alice, RoleAdmin, auth.ScopeAccount), ActionDelete, bobChirp, false},
+		{"no scopes", subject(alice, RoleAdmin, ""), ActionDelete, aliceChirp, false},
+
+		// Anonymous and unknown roles.
+		{"anonymous deletes chirp", Subject{Scopes: allScopes}, ActionDelete, Resource{Kind: ResourceChirp}, false},
+		{"unknown role deletes other's chirp", subject(alice, Role("owner")), ActionDelete, bobChirp, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Authorize(tt.subject, tt.action, tt.resource)
+			if tt.allowed && err != nil {
+				t.Fatalf("Authorize = %v, want allowed", err)
+			}
+			if !tt.allowed && !errors.Is(err, ErrForbidden) {
+				t.Fatalf("Authorize = %v, want %v", err, ErrForbidden)
+			}
+		})
+	}
+}
+
+func TestParseRole(t *testing.T) {
+	for _, role := range []Role{RoleUser, RoleModerator, RoleAdmin} {
+		if got, err := ParseRole(string(role)); err != nil || got != role {
+			t.Errorf("ParseRole(%q) = %q, %v", role, got, err)
+		}
+	}
+	for _, s := range []string{"", "Admin", "root"} {
+		if _, err := ParseRole(s); err == nil {
+			t.Errorf("ParseRole(%q) accepted an unknown role", s)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+// Package entitlements decides what a plan lets its members do. Plan
+// definitions live in the database so they can be changed without a
+// deploy; an Engine caches them for a short while so handlers can ask on
+// every request.
+package entitlements
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+)
+
+// FreePlan is the plan of every user without an active subscription.
+const FreePlan = "free"
+
+var ErrUnknownPlan = errors.New("unknown plan")
+
+// Entitlements are the limits and features of a plan. A DailyChirpQuota or
+// RequestsPerMinute of 0 means unlimited.
+type Entitlements struct {
+	Plan                string
+	MaxChirpLength      int
+	CanEditChirps       bool
+	DailyChirpQuota     int
+	MaxMediaAttachments int
+	RequestsPerMinute   int
+}
+
+// Free is what the free plan allows when its definition can't be found,
+// which is what Chirpy allowed before plans existed.
+var Free = Entitlements{
+	Plan:           FreePlan,
+	MaxChirpLength: 140,
+}
+
+// Loader loads the definition of a plan. It returns ErrUnknownPlan if there
+// is no such plan.
+type Loader func(ctx context.Context, plan string) (Entitlements, error)
+
+type cachedPlan struct {
+	entitlements Entitlements
+	loadedAt     time.Time
+}
+
+// Engine answers what a plan is entitled to, caching each definition for
+// TTL. Instances that didn't make a change see it once their cache expires
+// or the plan is invalidated.
+type Engine struct {
+	load Loader
+	ttl  time.Duration
+
+	mu    sync.Mutex
+	plans map[string]cachedPlan
+}
+
+func NewEngine(load Loader, ttl time.Duration) *Engine {
+	return &Engine{
+		load:  load,
+		ttl:   ttl,
+		plans: map[string]cachedPlan{},
+	}
+}
+
+// ForPlan returns the entitlements of plan. Only errors from the Loader are
+// returned; a plan that doesn't exist falls back to Free so that a typo in
+// a subscription never locks its member out of posting.
+func (e *Engine) ForPlan(ctx context.Context, plan string) (Entitlements, error) {
+	if plan == "" {
+		plan = FreePlan
+	}
+
+	e.mu.Lock()
+	cached, ok := e.plans[plan]
+	e.mu.Unlock()
+	if ok && time.Since(cached.loadedAt) < e.ttl {
+		return cached.entitlements, nil
+	}
+
+	entitlements, err := e.load(ctx, plan)
+	if errors.Is(err, ErrUnknownPlan) {
+		log.Printf("Plan %q is not defined, using the free plan", plan)
+		entitlements = Free
+		if plan != FreePlan {
+			entitlements, err = e.ForPlan(ctx, FreePlan)
+		}
+	}
+	if err != nil && !errors.Is(err, ErrUnknownPlan) {
+		return Entitlements{}, err
+	}
+
+	e.mu.Lock()
+	e.plans[plan] = cachedPlan{entitlements: entitlements, loadedAt: time.Now()}
+	e.mu.Unlock()
+	return entitlements, nil
+}
+
+// Invalidate drops plan from the cache, so the next ForPlan loads it again.
+func (e *Engine) Invalidate(plan string) {
+	e.mu.Lock()
+	delete(e.plans, plan)
+	e.mu.Unlock()
+}
+
+// InvalidateAll empties the cache.
+func (e *Engine) InvalidateAll() {
+	e.mu.Lock()
+	clear(e.plans)
+	e.mu.Unlock()
+}
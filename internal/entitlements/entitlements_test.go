@@ -0,0 +1,165 @@
+package entitlements
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+var (
+	testFree = Entitlements{Plan: FreePlan, MaxChirpLength: 140, DailyChirpQuota: 100, RequestsPerMinute: 60}
+	// testRed's zero quota and rate mean unlimited and must come back as 0,
+	// not as the free plan's limits.
+	testRed = Entitlements{Plan: "chirpy_red", MaxChirpLength: 280, CanEditChirps: true, MaxMediaAttachments: 4}
+)
+
+// fakePlans is a Loader over an in-memory plan table that counts loads.
+type fakePlans struct {
+	plans map[string]Entitlements
+	err   error
+	loads map[string]int
+}
+
+func newFakePlans(plans ...Entitlements) *fakePlans {
+	f := &fakePlans{plans: map[string]Entitlements{}, loads: map[string]int{}}
+	for _, p := range plans {
+		f.plans[p.Plan] = p
+	}
+	return f
+}
+
+func (f *fakePlans) load(ctx context.Context, plan string) (Entitlements, error) {
+	f.loads[plan]++
+	if f.err != nil {
+		return Entitlements{}, f.err
+	}
+	p, ok := f.plans[plan]
+	if !ok {
+		return Entitlements{}, ErrUnknownPlan
+	}
+	return p, nil
+}
+
+func TestForPlan(t *testing.T) {
+	tests := []struct {
+		name  string
+		plans []Entitlements
+		plan  string
+		want  Entitlements
+	}{
+		{"defined plan", []Entitlements{testFree, testRed}, "chirpy_red", testRed},
+		{"no plan is free", []Entitlements{testFree, testRed}, "", testFree},
+		{"unknown plan falls back to free", []Entitlements{testFree, testRed}, "chirpy_blue", testFree},
+		{"free plan not defined", nil, FreePlan, Free},
+		{"unknown plan without a free plan", nil, "chirpy_blue", Free},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			engine := NewEngine(newFakePlans(tt.plans...).load, time.Minute)
+			got, err := engine.ForPlan(context.Background(), tt.plan)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("ForPlan(%q) = %+v, want %+v", tt.plan, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestForPlanLoaderError(t *testing.T) {
+	plans := newFakePlans(testFree)
+	plans.err = errors.New("connection refused")
+	engine := NewEngine(plans.load, time.Minute)
+
+	if _, err := engine.ForPlan(context.Background(), FreePlan); err == nil {
+		t.Fatal("ForPlan hid a loader error")
+	}
+
+	// Errors aren't cached: the next call tries again.
+	plans.err = nil
+	got, err := engine.ForPlan(context.Background(), FreePlan)
+	if err != nil || got != testFree {
+		t.Fatalf("ForPlan after recovery = %+v, %v", got, err)
+	}
+}
+
+func TestForPlanCache(t *testing.T) {
+	ctx := context.Background()
+	plans := newFakePlans(testFree, testRed)
+	engine := NewEngine(plans.load, time.Hour)
+
+	for range 3 {
+		if _, err := engine.ForPlan(ctx, "chirpy_red"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if plans.loads["chirpy_red"] != 1 {
+		t.Fatalf("plan loaded %d times within the TTL, want 1", plans.loads["chirpy_red"])
+	}
+
+	// A change isn't seen until the plan is invalidated.
+	changed := testRed
+	changed.MaxChirpLength = 500
+	plans.plans["chirpy_red"] = changed
+	if got, _ := engine.ForPlan(ctx, "chirpy_red"); got != testRed {
+		t.Fatalf("ForPlan = %+v before invalidation, want the cached %+v", got, testRed)
+	}
+
+	engine.Invalidate("chirpy_red")
+	if got, _ := engine.ForPlan(ctx, "chirpy_red"); got != changed {
+		t.Fatalf("ForPlan = %+v after Invalidate, want %+v", got, changed)
+	}
+
+	// Invalidate only drops the plan it names.
+	if _, err := engine.ForPlan(ctx, FreePlan); err != nil {
+		t.Fatal(err)
+	}
+	engine.Invalidate("chirpy_red")
+	if _, err := engine.ForPlan(ctx, FreePlan); err != nil {
+		t.Fatal(err)
+	}
+	if plans.loads[FreePlan] != 1 {
+		t.Fatalf("free plan loaded %d times, want 1", plans.loads[FreePlan])
+	}
+
+	engine.InvalidateAll()
+	engine.ForPlan(ctx, FreePlan)
+	engine.ForPlan(ctx, "chirpy_red")
+	if plans.loads[FreePlan] != 2 || plans.loads["chirpy_red"] != 3 {
+		t.Fatalf("after InvalidateAll loads = %v, want free 2 and chirpy_red 3", plans.loads)
+	}
+}
+
+func TestForPlanTTL(t *testing.T) {
+	plans := newFakePlans(testFree)
+	engine := NewEngine(plans.load, time.Millisecond)
+
+	engine.ForPlan(context.Background(), FreePlan)
+	time.Sleep(5 * time.Millisecond)
+	engine.ForPlan(context.Background(), FreePlan)
+
+	if plans.loads[FreePlan] != 2 {
+		t.Fatalf("plan loaded %d times across an expired TTL, want 2", plans.loads[FreePlan])
+	}
+}
+
+func TestForPlanCachesFallback(t *testing.T) {
+	plans := newFakePlans(testFree)
+	engine := NewEngine(plans.load, time.Hour)
+
+	engine.ForPlan(context.Background(), "chirpy_blue")
+	engine.ForPlan(context.Background(), "chirpy_blue")
+	if plans.loads["chirpy_blue"] != 1 {
+		t.Fatalf("unknown plan loaded %d times, want 1", plans.loads["chirpy_blue"])
+	}
+
+	// Once the plan is defined and invalidated it is used.
+	blue := Entitlements{Plan: "chirpy_blue", MaxChirpLength: 200}
+	plans.plans["chirpy_blue"] = blue
+	engine.Invalidate("chirpy_blue")
+	if got, _ := engine.ForPlan(context.Background(), "chirpy_blue"); got != blue {
+		t.Fatalf("ForPlan = %+v, want %+v", got, blue)
+	}
+}
@@ -0,0 +1,89 @@
+// Package events is the bus Chirpy instances tell each other about domain
+// events on, such as a chirp being posted or a plan changing. Every
+// instance, including the publisher, gets every event, so in-memory state
+// like caches and live streams can be kept in step across instances.
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+)
+
+// Reconnected is delivered when a bus may have missed events, after it lost
+// its connection. Subscribers should drop anything cached from events.
+const Reconnected = "bus.reconnected"
+
+type Event struct {
+	Type string          `json:"type"`
+	Data json.RawMessage `json:"data"`
+}
+
+// Handler handles an event. Handlers are called one at a time and must not
+// block, or they hold up every other event.
+type Handler func(Event)
+
+type Bus interface {
+	// Publish sends an event of eventType with data encoded as JSON.
+	Publish(ctx context.Context, eventType string, data any) error
+	// Subscribe calls h for every event until the returned function is
+	// called.
+	Subscribe(h Handler) (unsubscribe func())
+	Close() error
+}
+
+type handlers struct {
+	mu   sync.Mutex
+	next int
+	m    map[int]Handler
+}
+
+func (hs *handlers) add(h Handler) func() {
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+	if hs.m == nil {
+		hs.m = map[int]Handler{}
+	}
+	id := hs.next
+	hs.next++
+	hs.m[id] = h
+	return func() {
+		hs.mu.Lock()
+		delete(hs.m, id)
+		hs.mu.Unlock()
+	}
+}
+
+func (hs *handlers) dispatch(e Event) {
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+	for _, h := range hs.m {
+		h(e)
+	}
+}
+
+// Local is a bus within one process, for running a single instance.
+type Local struct {
+	handlers handlers
+}
+
+func NewLocal() *Local {
+	return &Local{}
+}
+
+func (l *Local) Publish(ctx context.Context, eventType string, data any) error {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	l.handlers.dispatch(Event{Type: eventType, Data: raw})
+	return nil
+}
+
+func (l *Local) Subscribe(h Handler) func() {
+	return l.handlers.add(h)
+}
+
+func (l *Local) Close() error {
+	return nil
+}
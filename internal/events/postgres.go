@@ -0,0 +1,122 @@
+package events
+
+import (
+	"bytes"
+	"chirpy/internal/database"
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// maxPayload is the largest NOTIFY payload Postgres accepts by default,
+// less one.
+const maxPayload = 7999
+
+var ErrPayloadTooLarge = errors.New("event is too large to send with NOTIFY")
+
+// Postgres is a bus over Postgres LISTEN/NOTIFY, shared by every instance
+// using the same database. Events are not stored: an instance that is
+// disconnected when one is sent misses it, and gets a Reconnected event
+// once it is back.
+type Postgres struct {
+	db       *database.Queries
+	listener *pq.Listener
+	channel  string
+	handlers handlers
+	done     chan struct{}
+}
+
+// NewPostgres listens on channel over a connection of its own to connStr,
+// and publishes through db.
+func NewPostgres(db *database.Queries, connStr, channel string) (*Postgres, error) {
+	listener := pq.NewListener(connStr, time.Second*10, time.Minute, func(event pq.ListenerEventType, err error) {
+		if err != nil {
+			log.Printf("Event bus connection error: %s", err)
+		}
+	})
+	if err := listener.Listen(channel); err != nil {
+		listener.Close()
+		return nil, err
+	}
+
+	p := &Postgres{
+		db:       db,
+		listener: listener,
+		channel:  channel,
+		done:     make(chan struct{}),
+	}
+	go p.run()
+	return p, nil
+}
+
+func (p *Postgres) run() {
+	for {
+		select {
+		case <-p.done:
+			return
+		case n := <-p.listener.Notify:
+			// A nil notification means the connection was re-established,
+			// and anything sent in between is lost.
+			if n == nil {
+				p.handlers.dispatch(Event{Type: Reconnected})
+				continue
+			}
+			var e Event
+			if err := json.Unmarshal([]byte(n.Extra), &e); err != nil {
+				log.Printf("Ignoring malformed event on %s: %s", p.channel, err)
+				continue
+			}
+			p.handlers.dispatch(e)
+		case <-time.After(time.Minute):
+			// Make sure the connection is still alive when it's quiet.
+			go p.listener.Ping()
+		}
+	}
+}
+
+func (p *Postgres) Publish(ctx context.Context, eventType string, data any) error {
+	raw, err := marshal(data)
+	if err != nil {
+		return err
+	}
+	payload, err := marshal(Event{Type: eventType, Data: raw})
+	if err != nil {
+		return err
+	}
+	// Other instances can't be told about an event this large, but this
+	// one still is.
+	if len(payload) > maxPayload {
+		p.handlers.dispatch(Event{Type: eventType, Data: raw})
+		return ErrPayloadTooLarge
+	}
+
+	return p.db.NotifyEvent(ctx, database.NotifyEventParams{
+		Channel: p.channel,
+		Payload: string(payload),
+	})
+}
+
+// marshal encodes v without escaping HTML characters, which would make
+// URLs full of & up to six times longer and use up the payload limit.
+func marshal(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(v); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+func (p *Postgres) Subscribe(h Handler) func() {
+	return p.handlers.add(h)
+}
+
+func (p *Postgres) Close() error {
+	close(p.done)
+	return p.listener.Close()
+}
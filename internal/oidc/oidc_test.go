@@ -0,0 +1,335 @@
+package oidc
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	testClientID = "chirpy"
+	testNonce    = "n-0S6_WzA2Mj"
+	testKeyID    = "key-1"
+)
+
+var testKey = mustRSAKey()
+
+func mustRSAKey() *rsa.PrivateKey {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		panic(err)
+	}
+	return key
+}
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func rsaJWK(kid string, key *rsa.PublicKey) jwk {
+	return jwk{
+		KeyType: "RSA",
+		KeyID:   kid,
+		Use:     "sig",
+		N:       b64(key.N.Bytes()),
+		E:       b64(big.NewInt(int64(key.E)).Bytes()),
+	}
+}
+
+// mockIdP is a local OpenID provider serving discovery and a JWKS.
+type mockIdP struct {
+	server    *httptest.Server
+	metadata  Metadata
+	keys      jwkSet
+	jwksCalls atomic.Int32
+}
+
+func newMockIdP(t *testing.T) *mockIdP {
+	t.Helper()
+	idp := &mockIdP{keys: jwkSet{Keys: []jwk{rsaJWK(testKeyID, &testKey.PublicKey)}}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(idp.metadata)
+	})
+	mux.HandleFunc("GET /jwks", func(w http.ResponseWriter, r *http.Request) {
+		idp.jwksCalls.Add(1)
+		json.NewEncoder(w).Encode(idp.keys)
+	})
+	idp.server = httptest.NewServer(mux)
+	t.Cleanup(idp.server.Close)
+
+	idp.metadata = Metadata{
+		Issuer:                idp.server.URL,
+		AuthorizationEndpoint: idp.server.URL + "/authorize",
+		TokenEndpoint:         idp.server.URL + "/token",
+		JWKSURI:               idp.server.URL + "/jwks",
+		SigningAlgs:           []string{"RS256"},
+	}
+	return idp
+}
+
+func (idp *mockIdP) provider() *Provider {
+	return NewProvider("mock", idp.server.URL, testClientID, "secret", "http://localhost:8080/api/oidc/mock/callback", nil)
+}
+
+// claims returns valid ID token claims for a sign-in to the test client.
+func (idp *mockIdP) claims() jwt.MapClaims {
+	now := time.Now()
+	return jwt.MapClaims{
+		"iss":   idp.server.URL,
+		"sub":   "user-123",
+		"aud":   testClientID,
+		"exp":   now.Add(time.Hour).Unix(),
+		"iat":   now.Unix(),
+		"nonce": testNonce,
+		"email": "user@example.com",
+	}
+}
+
+func sign(t *testing.T, method jwt.SigningMethod, kid string, key any, claims jwt.MapClaims) string {
+	t.Helper()
+	token := jwt.NewWithClaims(method, claims)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func TestDiscover(t *testing.T) {
+	tests := []struct {
+		name    string
+		modify  func(*Metadata)
+		wantErr string
+	}{
+		{"valid", func(*Metadata) {}, ""},
+		{"other issuer", func(m *Metadata) { m.Issuer = "https://evil.example.com" }, "is for issuer"},
+		{"no jwks_uri", func(m *Metadata) { m.JWKSURI = "" }, "missing endpoints"},
+		{"no token endpoint", func(m *Metadata) { m.TokenEndpoint = "" }, "missing endpoints"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			idp := newMockIdP(t)
+			tt.modify(&idp.metadata)
+
+			metadata, err := idp.provider().Discover(context.Background())
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Discover error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if metadata.JWKSURI != idp.metadata.JWKSURI {
+				t.Errorf("JWKSURI = %q, want %q", metadata.JWKSURI, idp.metadata.JWKSURI)
+			}
+		})
+	}
+}
+
+func TestJWKSPublicKeys(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edKey, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	encryption := rsaJWK("enc", &testKey.PublicKey)
+	encryption.Use = "enc"
+	offCurve := jwk{KeyType: "EC", KeyID: "off-curve", Curve: "P-256", X: b64([]byte{1}), Y: b64([]byte{2})}
+
+	set := jwkSet{Keys: []jwk{
+		rsaJWK("rsa", &testKey.PublicKey),
+		{KeyType: "EC", KeyID: "ec", Curve: "P-256", X: b64(ecKey.X.Bytes()), Y: b64(ecKey.Y.Bytes())},
+		{KeyType: "OKP", KeyID: "ed", Curve: "Ed25519", X: b64(edKey)},
+		encryption,
+		offCurve,
+		{KeyType: "oct", KeyID: "symmetric", N: "c2VjcmV0"},
+		{KeyType: "OKP", KeyID: "x25519", Curve: "X25519", X: b64(edKey)},
+	}}
+
+	keys := set.publicKeys()
+	for _, kid := range []string{"rsa", "ec", "ed"} {
+		if keys[kid] == nil {
+			t.Errorf("key %q was skipped", kid)
+		}
+	}
+	for _, kid := range []string{"enc", "off-curve", "symmetric", "x25519"} {
+		if keys[kid] != nil {
+			t.Errorf("key %q should have been skipped", kid)
+		}
+	}
+}
+
+func TestVerifyIDToken(t *testing.T) {
+	idp := newMockIdP(t)
+	otherKey := mustRSAKey()
+
+	tests := []struct {
+		name   string
+		token  func() string
+		nonce  string
+		wantOK bool
+	}{
+		{"valid", func() string {
+			return sign(t, jwt.SigningMethodRS256, testKeyID, testKey, idp.claims())
+		}, testNonce, true},
+		{"several audiences with azp", func() string {
+			c := idp.claims()
+			c["aud"] = []string{testClientID, "other"}
+			c["azp"] = testClientID
+			return sign(t, jwt.SigningMethodRS256, testKeyID, testKey, c)
+		}, testNonce, true},
+		{"wrong issuer", func() string {
+			c := idp.claims()
+			c["iss"] = "https://evil.example.com"
+			return sign(t, jwt.SigningMethodRS256, testKeyID, testKey, c)
+		}, testNonce, false},
+		{"wrong audience", func() string {
+			c := idp.claims()
+			c["aud"] = "someone-else"
+			return sign(t, jwt.SigningMethodRS256, testKeyID, testKey, c)
+		}, testNonce, false},
+		{"wrong azp", func() string {
+			c := idp.claims()
+			c["aud"] = []string{testClientID, "other"}
+			c["azp"] = "other"
+			return sign(t, jwt.SigningMethodRS256, testKeyID, testKey, c)
+		}, testNonce, false},
+		{"several audiences without azp", func() string {
+			c := idp.claims()
+			c["aud"] = []string{testClientID, "other"}
+			return sign(t, jwt.SigningMethodRS256, testKeyID, testKey, c)
+		}, testNonce, false},
+		{"nonce mismatch", func() string {
+			return sign(t, jwt.SigningMethodRS256, testKeyID, testKey, idp.claims())
+		}, "another-login", false},
+		{"no nonce expected", func() string {
+			c := idp.claims()
+			delete(c, "nonce")
+			return sign(t, jwt.SigningMethodRS256, testKeyID, testKey, c)
+		}, "", false},
+		{"expired", func() string {
+			c := idp.claims()
+			c["exp"] = time.Now().Add(-time.Minute).Unix()
+			return sign(t, jwt.SigningMethodRS256, testKeyID, testKey, c)
+		}, testNonce, false},
+		{"no expiry", func() string {
+			c := idp.claims()
+			delete(c, "exp")
+			return sign(t, jwt.SigningMethodRS256, testKeyID, testKey, c)
+		}, testNonce, false},
+		{"no subject", func() string {
+			c := idp.claims()
+			delete(c, "sub")
+			return sign(t, jwt.SigningMethodRS256, testKeyID, testKey, c)
+		}, testNonce, false},
+		{"alg none", func() string {
+			return sign(t, jwt.SigningMethodNone, testKeyID, jwt.UnsafeAllowNoneSignatureType, idp.claims())
+		}, testNonce, false},
+		{"HS256 with the client secret", func() string {
+			return sign(t, jwt.SigningMethodHS256, testKeyID, []byte("secret"), idp.claims())
+		}, testNonce, false},
+		{"signed by another key", func() string {
+			return sign(t, jwt.SigningMethodRS256, testKeyID, otherKey, idp.claims())
+		}, testNonce, false},
+		{"unknown kid", func() string {
+			return sign(t, jwt.SigningMethodRS256, "key-2", otherKey, idp.claims())
+		}, testNonce, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			idToken, err := idp.provider().VerifyIDToken(context.Background(), tt.token(), tt.nonce)
+			if tt.wantOK {
+				if err != nil {
+					t.Fatalf("VerifyIDToken: %v", err)
+				}
+				if idToken.Subject != "user-123" || idToken.Email != "user@example.com" {
+					t.Errorf("VerifyIDToken = %+v", idToken)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatal("VerifyIDToken accepted the token")
+			}
+		})
+	}
+}
+
+func TestVerifyIDTokenKeyRotation(t *testing.T) {
+	idp := newMockIdP(t)
+	provider := idp.provider()
+	ctx := context.Background()
+
+	if _, err := provider.VerifyIDToken(ctx, sign(t, jwt.SigningMethodRS256, testKeyID, testKey, idp.claims()), testNonce); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := provider.VerifyIDToken(ctx, sign(t, jwt.SigningMethodRS256, testKeyID, testKey, idp.claims()), testNonce); err != nil {
+		t.Fatal(err)
+	}
+	if calls := idp.jwksCalls.Load(); calls != 1 {
+		t.Fatalf("JWKS fetched %d times for a known key, want 1", calls)
+	}
+
+	// The provider rotates to a new key. Until keyRefreshInterval has
+	// passed, an unknown kid doesn't download the JWKS again.
+	newKey := mustRSAKey()
+	idp.keys.Keys = append(idp.keys.Keys, rsaJWK("key-2", &newKey.PublicKey))
+	rotated := sign(t, jwt.SigningMethodRS256, "key-2", newKey, idp.claims())
+
+	if _, err := provider.VerifyIDToken(ctx, rotated, testNonce); err == nil {
+		t.Fatal("VerifyIDToken accepted a kid it hadn't fetched yet")
+	}
+	if calls := idp.jwksCalls.Load(); calls != 1 {
+		t.Fatalf("JWKS fetched %d times within the refresh interval, want 1", calls)
+	}
+
+	provider.mu.Lock()
+	provider.keysFetchedAt = time.Now().Add(-keyRefreshInterval)
+	provider.mu.Unlock()
+
+	if _, err := provider.VerifyIDToken(ctx, rotated, testNonce); err != nil {
+		t.Fatalf("VerifyIDToken after rotation: %v", err)
+	}
+	if calls := idp.jwksCalls.Load(); calls != 2 {
+		t.Fatalf("JWKS fetched %d times, want 2", calls)
+	}
+}
+
+func TestVerifyIDTokenWithoutKeyID(t *testing.T) {
+	idp := newMockIdP(t)
+	token := sign(t, jwt.SigningMethodRS256, "", testKey, idp.claims())
+
+	if _, err := idp.provider().VerifyIDToken(context.Background(), token, testNonce); err != nil {
+		t.Fatalf("VerifyIDToken with a single published key: %v", err)
+	}
+
+	otherKey := mustRSAKey()
+	idp.keys.Keys = append(idp.keys.Keys, rsaJWK("key-2", &otherKey.PublicKey))
+	if _, err := idp.provider().VerifyIDToken(context.Background(), token, testNonce); err == nil {
+		t.Fatal("VerifyIDToken picked a key for a token without kid among several")
+	}
+}
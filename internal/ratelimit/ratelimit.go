@@ -0,0 +1,85 @@
+// Package ratelimit counts requests per key in fixed windows. Counts are
+// kept in memory, so each instance enforces its limits on its own share of
+// the traffic.
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+type window struct {
+	start time.Time
+	count int
+}
+
+// Limiter allows up to a limit of requests per key in each Window.
+type Limiter struct {
+	Window time.Duration
+
+	mu        sync.Mutex
+	windows   map[string]*window
+	lastSweep time.Time
+}
+
+func New(length time.Duration) *Limiter {
+	return &Limiter{
+		Window:  length,
+		windows: map[string]*window{},
+	}
+}
+
+// Decision is the outcome of Allow. Remaining is how many more requests
+// the current window allows, and RetryAfter how long until it resets.
+type Decision struct {
+	Allowed    bool
+	Remaining  int
+	RetryAfter time.Duration
+}
+
+// Allow counts a request for key at now and decides whether it is within
+// limit. A limit of 0 or less allows everything without counting.
+func (l *Limiter) Allow(key string, limit int, now time.Time) Decision {
+	if limit <= 0 {
+		return Decision{Allowed: true}
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.sweep(now)
+
+	w, ok := l.windows[key]
+	if !ok || now.Sub(w.start) >= l.Window {
+		w = &window{start: now}
+		l.windows[key] = w
+	}
+
+	retryAfter := w.start.Add(l.Window).Sub(now)
+	if w.count >= limit {
+		return Decision{Allowed: false, RetryAfter: retryAfter}
+	}
+	w.count++
+	return Decision{Allowed: true, Remaining: limit - w.count, RetryAfter: retryAfter}
+}
+
+// sweep forgets windows that have ended, at most once per Window, so keys
+// that stop sending requests don't pile up.
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < l.Window {
+		return
+	}
+	for key, w := range l.windows {
+		if now.Sub(w.start) >= l.Window {
+			delete(l.windows, key)
+		}
+	}
+	l.lastSweep = now
+}
+
+// Reset forgets key's current window, for when its limit changes.
+func (l *Limiter) Reset(key string) {
+	l.mu.Lock()
+	delete(l.windows, key)
+	l.mu.Unlock()
+}
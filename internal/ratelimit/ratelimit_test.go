@@ -0,0 +1,76 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAllow(t *testing.T) {
+	l := New(time.Minute)
+	start := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	for i := range 3 {
+		d := l.Allow("alice", 3, start.Add(time.Duration(i)*time.Second))
+		if !d.Allowed || d.Remaining != 2-i {
+			t.Fatalf("request %d = %+v, want allowed with %d remaining", i+1, d, 2-i)
+		}
+	}
+
+	d := l.Allow("alice", 3, start.Add(10*time.Second))
+	if d.Allowed || d.RetryAfter != 50*time.Second {
+		t.Fatalf("fourth request = %+v, want refused for 50s", d)
+	}
+
+	// Other keys have windows of their own.
+	if d := l.Allow("bob", 3, start.Add(10*time.Second)); !d.Allowed {
+		t.Fatalf("bob was limited by alice's requests")
+	}
+
+	// A new window starts once the old one has ended.
+	if d := l.Allow("alice", 3, start.Add(time.Minute)); !d.Allowed || d.Remaining != 2 {
+		t.Fatalf("request in the next window = %+v", d)
+	}
+}
+
+func TestAllowUnlimited(t *testing.T) {
+	l := New(time.Minute)
+	now := time.Now()
+	for _, limit := range []int{0, -1} {
+		for range 1000 {
+			if d := l.Allow("alice", limit, now); !d.Allowed {
+				t.Fatalf("limit %d refused a request", limit)
+			}
+		}
+	}
+	if len(l.windows) != 0 {
+		t.Errorf("unlimited requests were counted")
+	}
+}
+
+func TestReset(t *testing.T) {
+	l := New(time.Minute)
+	now := time.Now()
+	l.Allow("alice", 1, now)
+	if d := l.Allow("alice", 1, now); d.Allowed {
+		t.Fatal("second request allowed over a limit of 1")
+	}
+
+	l.Reset("alice")
+	if d := l.Allow("alice", 1, now); !d.Allowed {
+		t.Fatal("request refused after Reset")
+	}
+}
+
+func TestSweep(t *testing.T) {
+	l := New(time.Minute)
+	start := time.Now()
+	l.Allow("alice", 10, start)
+	l.Allow("bob", 10, start.Add(2*time.Minute))
+
+	if _, ok := l.windows["alice"]; ok {
+		t.Error("ended window was not swept")
+	}
+	if _, ok := l.windows["bob"]; !ok {
+		t.Error("current window was swept")
+	}
+}
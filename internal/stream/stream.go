@@ -0,0 +1,89 @@
+// Package stream fans events out to live subscribers, such as clients
+// connected to the chirp stream. A Hub never waits for a subscriber: one
+// that falls too far behind is dropped, and is expected to reconnect and
+// catch up from wherever the events are stored.
+package stream
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Event is one change to a chirp. IDs sort in the order the changes
+// happened, so a client can resume after the last ID it saw.
+type Event struct {
+	ID       string
+	Type     string
+	AuthorID uuid.UUID
+	Data     []byte
+}
+
+// EventID is the ID of the change of kind to chirp at t. Kind orders
+// changes to the same chirp made in the same microsecond.
+func EventID(t time.Time, chirpID uuid.UUID, kind byte) string {
+	return fmt.Sprintf("%016x-%s-%c", t.UnixMicro(), chirpID, kind)
+}
+
+// Subscription receives the events its filter accepts.
+type Subscription struct {
+	events chan Event
+	filter func(Event) bool
+}
+
+// Events is closed when the subscription ends, by Unsubscribe or because
+// the subscriber fell behind.
+func (s *Subscription) Events() <-chan Event {
+	return s.events
+}
+
+type Hub struct {
+	mu   sync.Mutex
+	subs map[*Subscription]struct{}
+}
+
+func NewHub() *Hub {
+	return &Hub{subs: map[*Subscription]struct{}{}}
+}
+
+// Subscribe starts a subscription that can fall buffer events behind
+// before it is dropped. A nil filter accepts every event.
+func (h *Hub) Subscribe(buffer int, filter func(Event) bool) *Subscription {
+	s := &Subscription{
+		events: make(chan Event, buffer),
+		filter: filter,
+	}
+	h.mu.Lock()
+	h.subs[s] = struct{}{}
+	h.mu.Unlock()
+	return s
+}
+
+func (h *Hub) Unsubscribe(s *Subscription) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.subs[s]; ok {
+		delete(h.subs, s)
+		close(s.events)
+	}
+}
+
+// Publish hands e to every subscription that wants it without blocking.
+// Subscriptions whose buffer is full are dropped.
+func (h *Hub) Publish(e Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for s := range h.subs {
+		if s.filter != nil && !s.filter(e) {
+			continue
+		}
+		select {
+		case s.events <- e:
+		default:
+			delete(h.subs, s)
+			close(s.events)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package webhook
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestIsPublic(t *testing.T) {
+	tests := []struct {
+		ip     string
+		public bool
+	}{
+		{"93.184.216.34", true},
+		{"2606:2800:220:1:248:1893:25c8:1946", true},
+		{"100.63.255.255", true},
+		{"100.128.0.0", true},
+		{"198.20.0.0", true},
+
+		{"127.0.0.1", false},
+		{"::1", false},
+		{"10.0.0.1", false},
+		{"172.16.0.1", false},
+		{"192.168.1.1", false},
+		{"fd00::1", false},
+		{"169.254.169.254", false},
+		{"fe80::1", false},
+		{"224.0.0.1", false},
+		{"0.0.0.0", false},
+		{"::", false},
+		{"::ffff:10.0.0.1", false},
+
+		// "This network".
+		{"0.1.2.3", false},
+		// Carrier-grade NAT.
+		{"100.64.0.1", false},
+		{"100.127.255.254", false},
+		// Benchmarking.
+		{"198.18.0.1", false},
+		{"198.19.255.254", false},
+		// Reserved and broadcast.
+		{"240.0.0.1", false},
+		{"255.255.255.255", false},
+		// NAT64, here wrapping 127.0.0.1 and 10.0.0.1.
+		{"64:ff9b::7f00:1", false},
+		{"64:ff9b::a00:1", false},
+		{"64:ff9b:1::a00:1", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.ip, func(t *testing.T) {
+			ip := net.ParseIP(tt.ip)
+			if ip == nil {
+				t.Fatalf("bad test address %q", tt.ip)
+			}
+			if got := isPublic(ip); got != tt.public {
+				t.Errorf("isPublic(%s) = %v, want %v", tt.ip, got, tt.public)
+			}
+		})
+	}
+}
+
+func TestNewClientRefusesPrivateAddresses(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	_, err := NewClient(time.Second, false).Get(server.URL)
+	if !errors.Is(err, ErrPrivateAddress) {
+		t.Fatalf("Get %s = %v, want %v", server.URL, err, ErrPrivateAddress)
+	}
+
+	resp, err := NewClient(time.Second, true).Get(server.URL)
+	if err != nil {
+		t.Fatalf("Get %s with private addresses allowed: %v", server.URL, err)
+	}
+	resp.Body.Close()
+}
@@ -0,0 +1,44 @@
+package webhook
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestVerify(t *testing.T) {
+	const secret = "whsec_test"
+	body := []byte(`{"event":"user.upgraded"}`)
+	signedAt := time.Unix(1718000000, 0)
+	header := Sign(secret, signedAt, body)
+	other := Sign("whsec_old", signedAt, body)
+
+	tests := []struct {
+		name   string
+		secret string
+		header string
+		body   []byte
+		now    time.Time
+		want   error
+	}{
+		{"valid", secret, header, body, signedAt, nil},
+		{"within tolerance", secret, header, body, signedAt.Add(5 * time.Minute), nil},
+		{"rotated secret", secret, other + "," + header[len("t=1718000000,"):], body, signedAt, nil},
+		{"wrong secret", "whsec_other", header, body, signedAt, ErrInvalidSignature},
+		{"tampered body", secret, header, []byte(`{"event":"user.downgraded"}`), signedAt, ErrInvalidSignature},
+		{"too old", secret, header, body, signedAt.Add(6 * time.Minute), ErrStaleSignature},
+		{"from the future", secret, header, body, signedAt.Add(-6 * time.Minute), ErrStaleSignature},
+		{"empty", secret, "", body, signedAt, ErrMissingSignature},
+		{"no timestamp", secret, header[len("t=1718000000,"):], body, signedAt, ErrMissingSignature},
+		{"bad timestamp", secret, "t=soon," + header[len("t=1718000000,"):], body, signedAt, ErrMissingSignature},
+		{"no signature", secret, "t=1718000000", body, signedAt, ErrMissingSignature},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Verify(tt.secret, tt.header, tt.body, tt.now, 5*time.Minute)
+			if !errors.Is(err, tt.want) {
+				t.Errorf("Verify = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,268 @@
+// Package websocket is a small server side of RFC 6455, enough to push
+// messages to a client and keep the connection healthy. It does not
+// support extensions or fragmented messages from the client, and reads
+// client data only to answer control frames.
+package websocket
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+const (
+	opContinuation = 0x0
+	opText         = 0x1
+	opBinary       = 0x2
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xA
+)
+
+// Close codes used by the server.
+const (
+	CloseNormal          = 1000
+	CloseGoingAway       = 1001
+	CloseProtocolError   = 1002
+	ClosePolicyViolation = 1008
+	CloseMessageTooBig   = 1009
+	CloseTryAgainLater   = 1013
+)
+
+// maxClientFrame is the largest frame a client may send. The server only
+// expects control frames, whose payload is at most 125 bytes.
+const maxClientFrame = 4096
+
+var (
+	ErrBadHandshake = errors.New("not a valid websocket handshake")
+	ErrClosed       = errors.New("websocket connection closed")
+)
+
+// Conn is an upgraded connection. Writes may come from several goroutines.
+type Conn struct {
+	conn net.Conn
+	br   *bufio.Reader
+
+	writeMu sync.Mutex
+	closed  bool
+}
+
+func headerContains(h http.Header, name, token string) bool {
+	for _, value := range h.Values(name) {
+		for _, part := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(part), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// IsUpgrade reports whether r asks to switch to the websocket protocol.
+func IsUpgrade(r *http.Request) bool {
+	return headerContains(r.Header, "Connection", "upgrade") && headerContains(r.Header, "Upgrade", "websocket")
+}
+
+// AcceptKey is the Sec-WebSocket-Accept value for a client's key.
+func AcceptKey(key string) string {
+	sum := sha1.Sum([]byte(key + acceptGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// Upgrade completes the opening handshake and takes over the connection.
+// On a bad handshake it responds with a 400 itself and returns
+// ErrBadHandshake.
+func Upgrade(w http.ResponseWriter, r *http.Request) (*Conn, error) {
+	key := r.Header.Get("Sec-WebSocket-Key")
+	decoded, err := base64.StdEncoding.DecodeString(key)
+	if r.Method != http.MethodGet || !IsUpgrade(r) || r.Header.Get("Sec-WebSocket-Version") != "13" || err != nil || len(decoded) != 16 {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, ErrBadHandshake.Error(), http.StatusBadRequest)
+		return nil, ErrBadHandshake
+	}
+
+	conn, rw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		return nil, err
+	}
+
+	response := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + AcceptKey(key) + "\r\n\r\n"
+	if _, err := rw.WriteString(response); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if err := rw.Flush(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	// Clear any deadline the HTTP server set on the connection.
+	conn.SetDeadline(time.Time{})
+	return &Conn{conn: conn, br: rw.Reader}, nil
+}
+
+func (c *Conn) writeFrame(opcode byte, payload []byte, deadline time.Time) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if c.closed {
+		return ErrClosed
+	}
+
+	header := make([]byte, 2, 10)
+	header[0] = 0x80 | opcode
+	switch n := len(payload); {
+	case n < 126:
+		header[1] = byte(n)
+	case n <= 0xFFFF:
+		header[1] = 126
+		header = binary.BigEndian.AppendUint16(header, uint16(n))
+	default:
+		header[1] = 127
+		header = binary.BigEndian.AppendUint64(header, uint64(n))
+	}
+
+	c.conn.SetWriteDeadline(deadline)
+	if _, err := c.conn.Write(append(header, payload...)); err != nil {
+		return err
+	}
+	if opcode == opClose {
+		c.closed = true
+	}
+	return nil
+}
+
+// WriteText sends a text message. A client that doesn't take it before
+// deadline gets the connection broken.
+func (c *Conn) WriteText(data []byte, deadline time.Time) error {
+	return c.writeFrame(opText, data, deadline)
+}
+
+// Ping sends a ping, which the client has to answer with a pong.
+func (c *Conn) Ping(deadline time.Time) error {
+	return c.writeFrame(opPing, nil, deadline)
+}
+
+// CloseWithCode starts the closing handshake. The connection itself is
+// closed by Close.
+func (c *Conn) CloseWithCode(code int, reason string, deadline time.Time) error {
+	if len(reason) > 123 {
+		reason = reason[:123]
+	}
+	payload := binary.BigEndian.AppendUint16(nil, uint16(code))
+	return c.writeFrame(opClose, append(payload, reason...), deadline)
+}
+
+func (c *Conn) Close() error {
+	return c.conn.Close()
+}
+
+// ReadLoop reads client frames until the connection fails or the client
+// closes it, answering pings and closes. Each frame has to arrive within
+// idle of the last, so a client that stops answering pings is dropped.
+// Data messages are read and discarded. It returns the error that ended
+// the connection, which is io.EOF for a clean close.
+func (c *Conn) ReadLoop(idle time.Duration) error {
+	for {
+		c.conn.SetReadDeadline(time.Now().Add(idle))
+
+		opcode, payload, err := c.readFrame()
+		if err != nil {
+			if errors.Is(err, errFrameTooBig) {
+				c.CloseWithCode(CloseMessageTooBig, "", time.Now().Add(time.Second))
+			} else if errors.Is(err, errProtocol) {
+				c.CloseWithCode(CloseProtocolError, "", time.Now().Add(time.Second))
+			}
+			return err
+		}
+
+		switch opcode {
+		case opPing:
+			if err := c.writeFrame(opPong, payload, time.Now().Add(idle)); err != nil {
+				return err
+			}
+		case opClose:
+			code := CloseNormal
+			if len(payload) >= 2 {
+				code = int(binary.BigEndian.Uint16(payload))
+			}
+			c.CloseWithCode(code, "", time.Now().Add(time.Second))
+			return io.EOF
+		}
+	}
+}
+
+var (
+	errFrameTooBig = errors.New("websocket frame too big")
+	errProtocol    = errors.New("websocket protocol error")
+)
+
+func (c *Conn) readFrame() (byte, []byte, error) {
+	var head [2]byte
+	if _, err := io.ReadFull(c.br, head[:]); err != nil {
+		return 0, nil, err
+	}
+
+	opcode := head[0] & 0x0F
+	masked := head[1]&0x80 != 0
+	// Clients must mask every frame, and may not use the reserved bits
+	// without an extension.
+	if !masked || head[0]&0x70 != 0 {
+		return 0, nil, errProtocol
+	}
+	switch opcode {
+	case opContinuation, opText, opBinary, opClose, opPing, opPong:
+	default:
+		return 0, nil, errProtocol
+	}
+	if opcode >= opClose && head[0]&0x80 == 0 {
+		return 0, nil, errProtocol
+	}
+
+	length := uint64(head[1] & 0x7F)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return 0, nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return 0, nil, err
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+	if opcode >= opClose && length > 125 {
+		return 0, nil, errProtocol
+	}
+	if length > maxClientFrame {
+		return 0, nil, errFrameTooBig
+	}
+
+	var mask [4]byte
+	if _, err := io.ReadFull(c.br, mask[:]); err != nil {
+		return 0, nil, err
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(c.br, payload); err != nil {
+		return 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return opcode, payload, nil
+}
@@ -0,0 +1,86 @@
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestAcceptKey(t *testing.T) {
+	// RFC 6455 section 1.3.
+	const want = "s3pPLMBiTxaQ9kYGzzhZRbK+xOo="
+	if got := AcceptKey("dGhlIHNhbXBsZSBub25jZQ=="); got != want {
+		t.Errorf("AcceptKey = %s, want %s", got, want)
+	}
+}
+
+// maskedFrame builds a final client frame with the RFC 6455 section 5.7
+// example mask.
+func maskedFrame(opcode byte, payload []byte) []byte {
+	mask := []byte{0x37, 0xfa, 0x21, 0x3d}
+	frame := []byte{0x80 | opcode}
+	switch {
+	case len(payload) < 126:
+		frame = append(frame, 0x80|byte(len(payload)))
+	case len(payload) <= 0xFFFF:
+		frame = append(frame, 0x80|126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(len(payload)))
+	default:
+		frame = append(frame, 0x80|127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(len(payload)))
+	}
+	frame = append(frame, mask...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+	return frame
+}
+
+func TestReadFrame(t *testing.T) {
+	hello := []byte("Hello")
+	medium := bytes.Repeat([]byte("a"), 300)
+
+	tests := []struct {
+		name    string
+		frame   []byte
+		opcode  byte
+		payload []byte
+		err     error
+	}{
+		// RFC 6455 section 5.7.
+		{"masked text", []byte{0x81, 0x85, 0x37, 0xfa, 0x21, 0x3d, 0x7f, 0x9f, 0x4d, 0x51, 0x58}, opText, hello, nil},
+		{"masked pong", []byte{0x8a, 0x85, 0x37, 0xfa, 0x21, 0x3d, 0x7f, 0x9f, 0x4d, 0x51, 0x58}, opPong, hello, nil},
+		{"unmasked text", []byte{0x81, 0x05, 0x48, 0x65, 0x6c, 0x6c, 0x6f}, 0, nil, errProtocol},
+
+		{"16-bit length", maskedFrame(opBinary, medium), opBinary, medium, nil},
+		{"empty close", maskedFrame(opClose, nil), opClose, []byte{}, nil},
+		{"reserved bit", append([]byte{0xC1}, maskedFrame(opText, hello)[1:]...), 0, nil, errProtocol},
+		{"unknown opcode", maskedFrame(0x3, hello), 0, nil, errProtocol},
+		{"fragmented ping", append([]byte{opPing}, maskedFrame(opPing, hello)[1:]...), 0, nil, errProtocol},
+		{"long control frame", maskedFrame(opPing, bytes.Repeat([]byte("a"), 126)), 0, nil, errProtocol},
+		{"too big", maskedFrame(opBinary, bytes.Repeat([]byte("a"), maxClientFrame+1)), 0, nil, errFrameTooBig},
+		{"64-bit length", maskedFrame(opBinary, bytes.Repeat([]byte("a"), 0x10000)), 0, nil, errFrameTooBig},
+		{"truncated payload", maskedFrame(opText, hello)[:8], 0, nil, io.ErrUnexpectedEOF},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Conn{br: bufio.NewReader(bytes.NewReader(tt.frame))}
+			opcode, payload, err := c.readFrame()
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("readFrame error = %v, want %v", err, tt.err)
+			}
+			if err != nil {
+				return
+			}
+			if opcode != tt.opcode {
+				t.Errorf("opcode = %#x, want %#x", opcode, tt.opcode)
+			}
+			if !bytes.Equal(payload, tt.payload) {
+				t.Errorf("payload = %q, want %q", payload, tt.payload)
+			}
+		})
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"chirpy/internal/auth"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// keyringFromEnv builds the JWT keyring. JWT_PRIVATE_KEYS lists PEM files of
+// active Ed25519 or RSA keys, the first of which signs new tokens, and
+// JWT_RETIRED_KEYS lists keys as path@time, each of which only verifies
+// until JWT_KEY_GRACE_PERIOD after the RFC 3339 time it was retired. SECRET
+// is an HS256 key: it signs when no private keys are configured and is
+// otherwise retired at SECRET_RETIRED_AT.
+//
+// The grace period can't be shorter than the longest-lived token the
+// keyring signs, so every token a retired key signed has expired by the
+// time the key is dropped.
+func keyringFromEnv(tokens tokenConfig) (*auth.Keyring, error) {
+	longestLived := max(tokens.MaxAccessTTL, emailVerificationLifetime)
+	gracePeriod, err := durationFromEnv("JWT_KEY_GRACE_PERIOD", longestLived)
+	if err != nil {
+		return nil, err
+	}
+	if gracePeriod < longestLived {
+		return nil, fmt.Errorf("JWT_KEY_GRACE_PERIOD must be at least %s, the longest token lifetime", longestLived)
+	}
+	leeway, err := durationFromEnv("JWT_LEEWAY", 30*time.Second)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := auth.NewKeyring(stringFromEnv("JWT_ISSUER", "chirpy"), stringFromEnv("JWT_AUDIENCE", "chirpy"), gracePeriod)
+	keys.Leeway = leeway
+
+	active := splitList(os.Getenv("JWT_PRIVATE_KEYS"))
+	for _, path := range active {
+		key, err := auth.LoadPrivateKeyFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if err := keys.Add(key); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, entry := range splitList(os.Getenv("JWT_RETIRED_KEYS")) {
+		at := strings.LastIndex(entry, "@")
+		if at < 0 {
+			return nil, fmt.Errorf("JWT_RETIRED_KEYS: give %s a retirement time, as %s@2006-01-02T15:04:05Z", entry, entry)
+		}
+		path := entry[:at]
+		key, err := auth.LoadPrivateKeyFile(path)
+		if err != nil {
+			return nil, err
+		}
+		key.RetiredAt, err = time.Parse(time.RFC3339, entry[at+1:])
+		if err != nil {
+			return nil, fmt.Errorf("JWT_RETIRED_KEYS: %s: %w", path, err)
+		}
+		if err := keys.Add(key); err != nil {
+			return nil, err
+		}
+	}
+
+	if secret := os.Getenv("SECRET"); secret != "" {
+		key, err := auth.NewHMACKey(secret)
+		if err != nil {
+			return nil, err
+		}
+		if len(active) > 0 {
+			key.RetiredAt, err = time.Parse(time.RFC3339, os.Getenv("SECRET_RETIRED_AT"))
+			if err != nil {
+				return nil, fmt.Errorf("SECRET_RETIRED_AT must be the RFC 3339 time SECRET stopped signing: %w", err)
+			}
+		}
+		if err := keys.Add(key); err != nil {
+			return nil, err
+		}
+	} else if len(active) == 0 {
+		return nil, errors.New("set SECRET or JWT_PRIVATE_KEYS to sign tokens")
+	}
+
+	return keys, nil
+}
+
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// handlerJWKS publishes the public keys access tokens can be verified with,
+// so other services never need the signing secret.
+func (apiCfg *apiConfig) handlerJWKS(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	respondWithJSON(w, http.StatusOK, map[string][]auth.JWK{
+		"keys": apiCfg.Keys.JWKS(),
+	})
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"chirpy/internal/database"
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	loginBackoffBase     = time.Second
+	loginLockoutDuration = time.Minute * 15
+	// loginFailureWindow is how long a key has to stay quiet before its
+	// failure count starts again from zero.
+	loginFailureWindow = time.Hour
+)
+
+// loginThrottle describes how failed logins against one kind of key are
+// slowed down. The first freeAttempts failures cost nothing, each one after
+// that doubles the wait before the next attempt, and at maxFailures the key
+// is locked out for loginLockoutDuration.
+type loginThrottle struct {
+	prefix       string
+	freeAttempts int32
+	maxFailures  int32
+}
+
+var (
+	// Accounts are throttled by the submitted email, whether or not it is
+	// registered, so the throttle does not reveal which emails exist.
+	accountLoginThrottle = loginThrottle{prefix: "email:", freeAttempts: 3, maxFailures: 10}
+	// A single IP may be shared by many users behind NAT, so it gets more
+	// room before it is slowed down.
+	ipLoginThrottle = loginThrottle{prefix: "ip:", freeAttempts: 20, maxFailures: 100}
+	// Login links are limited per email. Every request counts, registered
+	// address or not, so nobody can use Chirpy to flood an inbox.
+	magicLinkThrottle = loginThrottle{prefix: "magic:", freeAttempts: 3, maxFailures: 10}
+	// Password reset emails are limited the same way.
+	passwordResetThrottle = loginThrottle{prefix: "reset:", freeAttempts: 3, maxFailures: 10}
+)
+
+var errLoginThrottled = errors.New("Too many failed login attempts, try again later")
+
+func (t loginThrottle) key(value string) string {
+	return t.prefix + value
+}
+
+// delay returns how long the key is blocked after its failures'th failed
+// attempt, and whether that amounts to a full lockout.
+func (t loginThrottle) delay(failures int32) (time.Duration, bool) {
+	if failures >= t.maxFailures {
+		return loginLockoutDuration, true
+	}
+	if failures <= t.freeAttempts {
+		return 0, false
+	}
+	shift := min(failures-t.freeAttempts-1, 20)
+	return min(loginBackoffBase<<shift, loginLockoutDuration), false
+}
+
+func loginThrottleKeys(r *http.Request, email string) map[loginThrottle]string {
+	return map[loginThrottle]string{
+		accountLoginThrottle: accountLoginThrottle.key(strings.ToLower(strings.TrimSpace(email))),
+		ipLoginThrottle:      ipLoginThrottle.key(clientIP(r)),
+	}
+}
+
+// loginRetryAfter returns how long the caller has to wait before trying to
+// log in as email again, or zero if it may try now.
+func (apiCfg *apiConfig) loginRetryAfter(r *http.Request, email string) (time.Duration, error) {
+	var wait time.Duration
+	for _, key := range loginThrottleKeys(r, email) {
+		keyWait, err := apiCfg.throttleRetryAfter(r.Context(), key)
+		if err != nil {
+			return 0, err
+		}
+		wait = max(wait, keyWait)
+	}
+	return wait, nil
+}
+
+// throttleRetryAfter returns how long key stays blocked, or zero if it is
+// not blocked.
+func (apiCfg *apiConfig) throttleRetryAfter(ctx context.Context, key string) (time.Duration, error) {
+	throttle, err := apiCfg.DB.GetLoginThrottle(ctx, key)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	if !throttle.LockedUntil.Valid {
+		return 0, nil
+	}
+	return max(time.Until(throttle.LockedUntil.Time), 0), nil
+}
+
+// recordLoginFailure counts a failed login against both the email and the
+// caller's IP and blocks whichever has now failed too often.
+func (apiCfg *apiConfig) recordLoginFailure(ctx context.Context, r *http.Request, email string) {
+	for throttle, key := range loginThrottleKeys(r, email) {
+		apiCfg.recordThrottledAttempt(ctx, throttle, key)
+	}
+}
+
+// recordThrottledAttempt counts one attempt against key and blocks it for as
+// long as throttle asks once it has made too many.
+func (apiCfg *apiConfig) recordThrottledAttempt(ctx context.Context, throttle loginThrottle, key string) {
+	now := time.Now()
+	failures, err := apiCfg.DB.RecordLoginFailure(ctx, database.RecordLoginFailureParams{
+		ThrottleKey: key,
+		ResetBefore: now.Add(-loginFailureWindow),
+	})
+	if err != nil {
+		log.Printf("Error recording attempt for %s: %s", key, err)
+		return
+	}
+
+	delay, lockout := throttle.delay(failures)
+	if delay == 0 {
+		return
+	}
+	if lockout {
+		log.Printf("Login locked for %s until %s after %d attempts", key, now.Add(delay).Format(time.RFC3339), failures)
+	}
+
+	err = apiCfg.DB.LockLogin(ctx, database.LockLoginParams{
+		ThrottleKey: key,
+		LockedUntil: sql.NullTime{Time: now.Add(delay), Valid: true},
+	})
+	if err != nil {
+		log.Printf("Error locking login for %s: %s", key, err)
+	}
+}
+
+// clearLoginFailures forgets the failures against an account once its owner
+// logs in. The IP counter is left alone, so an attacker cannot reset it by
+// logging in to an account of their own.
+func (apiCfg *apiConfig) clearLoginFailures(ctx context.Context, email string) {
+	key := accountLoginThrottle.key(strings.ToLower(strings.TrimSpace(email)))
+	if err := apiCfg.DB.ClearLoginThrottle(ctx, key); err != nil {
+		log.Printf("Error clearing login throttle for %s: %s", key, err)
+	}
+}
+
+func respondLoginThrottled(w http.ResponseWriter, wait time.Duration) {
+	w.Header().Set("Retry-After", retryAfterSeconds(wait))
+	respondWithError(w, http.StatusTooManyRequests, errLoginThrottled.Error())
+}
+
+// retryAfterSeconds formats wait for a Retry-After header.
+func retryAfterSeconds(wait time.Duration) string {
+	seconds := int(wait.Round(time.Second).Seconds())
+	return strconv.Itoa(max(seconds, 1))
+}
@@ -2,17 +2,26 @@ package main
 
 import (
 	"chirpy/internal/auth"
+	"chirpy/internal/authz"
 	"chirpy/internal/database"
+	"chirpy/internal/entitlements"
+	"chirpy/internal/events"
+	"chirpy/internal/mailer"
+	"chirpy/internal/oidc"
+	"chirpy/internal/ratelimit"
+	"chirpy/internal/stream"
+	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
-	"sort"
 	"strings"
 	"sync/atomic"
 	"time"
+	"unicode/utf8"
 
 	"github.com/google/uuid"
 	"github.com/joho/godotenv"
@@ -22,10 +31,24 @@ import (
 var port string = "8080"
 
 type apiConfig struct {
+	// fileserverHits are this instance's hits not yet published on the
+	// event bus; totalHits are those every instance has published.
 	fileserverHits atomic.Int32
+	totalHits      atomic.Int64
 	DB             *database.Queries
-	Secret         string
-	PolkaSecret    string
+	DBConn         *sql.DB
+	Keys           *auth.Keyring
+	Tokens         tokenConfig
+	Passwords      passwordConfig
+	Polka          polkaConfig
+	Mailer         mailer.Mailer
+	BaseURL        string
+	OIDC           map[string]*oidc.Provider
+	Entitlements   *entitlements.Engine
+	RateLimiter    *ratelimit.Limiter
+	Webhooks       *webhookDispatcher
+	Stream         *stream.Hub
+	Events         events.Bus
 }
 
 func handlerReadiness(w http.ResponseWriter, r *http.Request) {
@@ -51,7 +74,7 @@ func (cfg *apiConfig) handlerMetris(w http.ResponseWriter, r *http.Request) {
     		<p>Chirpy has been visited %d times!</p>
   		</body>
 	</html>
-`, cfg.fileserverHits.Load())
+`, cfg.totalHits.Load()+int64(cfg.fileserverHits.Load()))
 }
 
 func (apiCfg *apiConfig) resetMetrics(w http.ResponseWriter, r *http.Request) {
@@ -66,7 +89,9 @@ func (apiCfg *apiConfig) resetMetrics(w http.ResponseWriter, r *http.Request) {
 		respondWithError(w, http.StatusInternalServerError, "Failed to delete users")
 		return
 	}
+	apiCfg.totalHits.Store(0)
 	apiCfg.fileserverHits.Store(0)
+	apiCfg.publishEvent(r.Context(), eventMetricsReset, struct{}{})
 
 	respondWithJSON(w, http.StatusOK, map[string]string{
 		"message": "Metrics reset successfully and users deleted.",
@@ -92,75 +117,155 @@ func censorString(str string) string {
 
 func (apiCfg *apiConfig) handlerValidateChirp(w http.ResponseWriter, r *http.Request) {
 	type parameters struct {
-		Body   string    `json:"body"`
-		UserID uuid.UUID `json:"user_id"`
+		Body      string     `json:"body"`
+		UserID    uuid.UUID  `json:"user_id"`
+		InReplyTo *uuid.UUID `json:"in_reply_to"`
+		MediaURLs []string   `json:"media_urls"`
 	}
 	params := parameters{}
 
 	decoder := json.NewDecoder(r.Body)
 	err := decoder.Decode(&params)
 	if err != nil {
-		respondWithJSON(w, http.StatusInternalServerError, []byte(`{"error": "Something went wrong"}`))
+		respondWithError(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+
+	subject := authenticatedSubject(r)
+
+	if !authorize(w, subject, authz.ActionCreate, authz.Resource{Kind: authz.ResourceChirp, OwnerID: subject.UserID}) {
+		return
 	}
 
-	if len(params.Body) > 140 {
-		respondWithJSON(w, http.StatusBadRequest, []byte(`{"error": "Chirp is too long"}`))
+	limits, err := apiCfg.entitlementsFor(r.Context(), subject.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to create chirp")
+		return
+	}
+
+	if utf8.RuneCountInString(params.Body) > limits.MaxChirpLength {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Chirp is too long, the limit is %d characters", limits.MaxChirpLength))
+		return
+	}
+	if err := validateMediaURLs(params.MediaURLs, limits); err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if params.MediaURLs == nil {
+		params.MediaURLs = []string{}
 	}
 	censoredString := censorString(params.Body)
 
-	token, err := auth.GetBearerToken(r.Header)
+	var parentID, rootID uuid.NullUUID
+	if params.InReplyTo != nil {
+		parent, err := apiCfg.DB.GetChirp(r.Context(), *params.InReplyTo)
+		if err != nil || parent.DeletedAt.Valid {
+			respondWithError(w, http.StatusNotFound, "Chirp being replied to not found")
+			return
+		}
+		parentID = uuid.NullUUID{UUID: parent.ID, Valid: true}
+		rootID = parent.RootID
+		if !rootID.Valid {
+			rootID = parentID
+		}
+	}
+
+	tx, err := apiCfg.DBConn.BeginTx(r.Context(), nil)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, `"error": "Authorization token is missing or invalid"`)
+		respondWithError(w, http.StatusInternalServerError, "Failed to create chirp")
 		return
 	}
+	defer tx.Rollback()
+	qtx := apiCfg.DB.WithTx(tx)
 
-	userId, err := auth.ValidateJWT(token, apiCfg.Secret)
+	withinQuota, err := checkChirpQuota(r.Context(), qtx, subject.UserID, limits)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Invalid or expired token")
+		respondWithError(w, http.StatusInternalServerError, "Failed to create chirp")
+		return
+	}
+	if !withinQuota {
+		respondWithError(w, http.StatusTooManyRequests, errChirpQuotaReached.Error())
 		return
 	}
 
-	chirp, err := apiCfg.DB.CreateChirp(r.Context(), database.CreateChirpParams{
-		Body:   censoredString,
-		UserID: userId,
+	chirp, err := qtx.CreateChirp(r.Context(), database.CreateChirpParams{
+		Body:      censoredString,
+		UserID:    subject.UserID,
+		ParentID:  parentID,
+		RootID:    rootID,
+		MediaUrls: params.MediaURLs,
 	})
 	if err != nil {
 		respondWithError(w, http.StatusUnauthorized, "Failed to create chirp")
 		return
 	}
-	respondWithJSON(w, http.StatusCreated, databaseChirpToChirp(chirp))
+	if err := tx.Commit(); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to create chirp")
+		return
+	}
+	response := databaseChirpToChirp(chirp)
+	apiCfg.publishEvent(r.Context(), eventChirpCreated, response)
+	apiCfg.publishWebhookEvent(r.Context(), webhookChirpCreated, uuid.NullUUID{}, response)
+	respondWithJSON(w, http.StatusCreated, response)
 }
 
 func (cfg *apiConfig) handlerGetChirps(w http.ResponseWriter, r *http.Request) {
-	authorIDStr := r.URL.Query().Get("author_id")
-	order := strings.ToLower(r.URL.Query().Get("sort"))
+	query := r.URL.Query()
+	authorIDStr := query.Get("author_id")
+	order := strings.ToLower(query.Get("sort"))
+
+	if order != "" && order != "asc" && order != "desc" {
+		respondWithError(w, http.StatusBadRequest, "sort must be asc or desc")
+		return
+	}
 
-	var chirps []database.Chirp
-	var err error
+	params, err := parsePageParams(query)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
 
+	var authorID uuid.NullUUID
 	if authorIDStr != "" {
 		parsedAuthorID, parseErr := uuid.Parse(authorIDStr)
 		if parseErr != nil {
 			respondWithError(w, http.StatusBadRequest, "Invalid author_id")
 			return
 		}
-		chirps, err = cfg.DB.GetChirpFromAuthorId(r.Context(), parsedAuthorID)
-	} else {
-		chirps, err = cfg.DB.GetChirps(r.Context())
+		authorID = uuid.NullUUID{UUID: parsedAuthorID, Valid: true}
 	}
 
+	chirps, err := fetchPage(params, order == "desc", func(p pageParams, limit int32, ascending bool) ([]database.Chirp, error) {
+		if ascending {
+			return cfg.DB.ListChirpsAfter(r.Context(), database.ListChirpsAfterParams{
+				AuthorID:        authorID,
+				CursorCreatedAt: p.cursorTime(),
+				CursorID:        p.cursorID(),
+				Limit:           limit,
+			})
+		}
+		return cfg.DB.ListChirpsBefore(r.Context(), database.ListChirpsBeforeParams{
+			AuthorID:        authorID,
+			CursorCreatedAt: p.cursorTime(),
+			CursorID:        p.cursorID(),
+			Limit:           limit,
+		})
+	})
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
 
-	if order == "desc" {
-		sort.Slice(chirps, func(i, j int) bool {
-			return chirps[i].CreatedAt.After(chirps[j].CreatedAt)
-		})
+	response := databaseChirpsToChirps(chirps.Items)
+	if wantsAuthor(r) {
+		if err := cfg.embedAuthors(r.Context(), chirpPointers(response)); err != nil {
+			respondWithError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
 	}
 
-	respondWithJSON(w, http.StatusOK, databaseChirpsToChirps(chirps))
+	setPageLinks(w, r, chirps, chirpCursor)
+	respondWithJSON(w, http.StatusOK, response)
 }
 
 func (cfg *apiConfig) handlerGetChirp(w http.ResponseWriter, r *http.Request) {
@@ -172,18 +277,66 @@ func (cfg *apiConfig) handlerGetChirp(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if chirp, ok := cfg.DB.GetChirp(r.Context(), parsedChirpID); ok == nil {
-		respondWithJSON(w, http.StatusOK, databaseChirpToChirp(chirp))
+	if chirp, ok := cfg.DB.GetChirp(r.Context(), parsedChirpID); ok == nil && !chirp.DeletedAt.Valid {
+		response := databaseChirpToChirp(chirp)
+		if wantsAuthor(r) {
+			if err := cfg.embedAuthors(r.Context(), []*Chirp{&response}); err != nil {
+				respondWithError(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+		}
+		respondWithJSON(w, http.StatusOK, response)
 	} else {
-		respondWithError(w, http.StatusNotFound, ok.Error())
+		respondWithError(w, http.StatusNotFound, "Chirp not found")
 		return
 	}
 }
 
+func (cfg *apiConfig) handlerGetThread(w http.ResponseWriter, r *http.Request) {
+	parsedChirpID, err := uuid.Parse(r.PathValue("chirpID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid chirp ID")
+		return
+	}
+
+	chirp, err := cfg.DB.GetChirp(r.Context(), parsedChirpID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Chirp not found")
+		return
+	}
+
+	rootID := chirp.ID
+	if chirp.RootID.Valid {
+		rootID = chirp.RootID.UUID
+	}
+
+	chirps, err := cfg.DB.GetThread(r.Context(), rootID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	thread, ok := databaseChirpsToThread(rootID, chirps)
+	if !ok {
+		respondWithError(w, http.StatusNotFound, "Thread not found")
+		return
+	}
+
+	if wantsAuthor(r) {
+		if err := cfg.embedAuthors(r.Context(), threadPointers(&thread)); err != nil {
+			respondWithError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+
+	respondWithJSON(w, http.StatusOK, thread)
+}
+
 func (apiCfg *apiConfig) handlerCreateUser(w http.ResponseWriter, r *http.Request) {
 	type parameters struct {
 		Email    string `json:"email"`
 		Password string `json:"password"`
+		Handle   string `json:"handle"`
 	}
 
 	decoder := json.NewDecoder(r.Body)
@@ -194,7 +347,24 @@ func (apiCfg *apiConfig) handlerCreateUser(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	hashed_password, err := auth.HashPassword(params.Password)
+	if err := validateEmail(params.Email); err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if params.Handle == "" {
+		params.Handle = defaultHandle()
+	} else if err := validateHandle(params.Handle); err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err := apiCfg.Passwords.Policy.Check(params.Password, params.Email); err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	hashed_password, err := apiCfg.Passwords.hash(params.Password)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Error processing password")
 		return
@@ -203,18 +373,26 @@ func (apiCfg *apiConfig) handlerCreateUser(w http.ResponseWriter, r *http.Reques
 	user, err := apiCfg.DB.CreateUser(r.Context(), database.CreateUserParams{
 		Email:          params.Email,
 		HashedPassword: hashed_password,
+		Handle:         params.Handle,
 	})
+	if isUniqueViolation(err) {
+		respondWithError(w, http.StatusConflict, "Email or handle is already taken")
+		return
+	}
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Error creating user")
 		return
 	}
-	respondWithJSON(w, 201, databaseUserToUser(user))
+
+	apiCfg.sendVerificationEmail(r.Context(), user)
+	respondWithJSON(w, 201, apiCfg.userResponse(r.Context(), user))
 }
 
 func (apiCfg *apiConfig) handlerLogin(w http.ResponseWriter, r *http.Request) {
 	type parameters struct {
-		Email    string `json:"email"`
-		Password string `json:"password"`
+		Email            string `json:"email"`
+		Password         string `json:"password"`
+		ExpiresInSeconds int    `json:"expires_in_seconds"`
 	}
 
 	decoder := json.NewDecoder(r.Body)
@@ -231,84 +409,344 @@ func (apiCfg *apiConfig) handlerLogin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	user, err := apiCfg.DB.GetUserFromEmail(r.Context(), params.Email)
+	user, err := apiCfg.checkPassword(r, params.Email, params.Password)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Error retrieving user")
+		respondPasswordError(w, err)
 		return
 	}
 
-	if err := auth.CheckPasswordHash(params.Password, user.HashedPassword); err == nil {
-		accessToken, err := auth.MakeJWT(user.ID, apiCfg.Secret)
-		if err != nil {
+	if apiCfg.requiresSecondFactor(w, r, user) {
+		return
+	}
+	apiCfg.completeLogin(w, r, user, apiCfg.Tokens.accessTTL(params.ExpiresInSeconds))
+}
+
+var errInvalidCredentials = errors.New("Incorrect email or password")
+
+// loginThrottledError is returned by checkPassword while the email or the
+// caller's IP is blocked after too many failed attempts.
+type loginThrottledError struct {
+	wait time.Duration
+}
+
+func (e loginThrottledError) Error() string {
+	return errLoginThrottled.Error()
+}
+
+// checkPassword runs the password step of every login: it enforces the
+// login throttle, checks the password, and upgrades an outdated hash. An
+// unknown email and a wrong password both return errInvalidCredentials,
+// and take just as long to do so.
+func (apiCfg *apiConfig) checkPassword(r *http.Request, email, password string) (database.User, error) {
+	wait, err := apiCfg.loginRetryAfter(r, email)
+	if err != nil {
+		return database.User{}, err
+	}
+	if wait > 0 {
+		return database.User{}, loginThrottledError{wait: wait}
+	}
+
+	user, err := apiCfg.DB.GetUserFromEmail(r.Context(), email)
+	hashedPassword := user.HashedPassword
+	if errors.Is(err, sql.ErrNoRows) {
+		hashedPassword = apiCfg.Passwords.DummyHash
+	} else if err != nil {
+		return database.User{}, err
+	}
+
+	if err := auth.CheckPasswordHash(password, hashedPassword); err != nil || user.ID == uuid.Nil {
+		apiCfg.recordLoginFailure(r.Context(), r, email)
+		return database.User{}, errInvalidCredentials
+	}
+
+	apiCfg.clearLoginFailures(r.Context(), email)
+	apiCfg.upgradePasswordHash(r.Context(), user, password)
+	return user, nil
+}
+
+func respondPasswordError(w http.ResponseWriter, err error) {
+	var throttled loginThrottledError
+	switch {
+	case errors.As(err, &throttled):
+		respondLoginThrottled(w, throttled.wait)
+	case errors.Is(err, errInvalidCredentials):
+		respondWithError(w, http.StatusUnauthorized, err.Error())
+	default:
+		respondWithError(w, http.StatusInternalServerError, "Error checking credentials")
+	}
+}
+
+// upgradePasswordHash re-hashes the user's password with the current
+// algorithm and parameters if their stored hash predates them. It only runs
+// after a successful login, the one time the plain password is at hand.
+func (apiCfg *apiConfig) upgradePasswordHash(ctx context.Context, user database.User, password string) {
+	if !auth.NeedsRehash(user.HashedPassword, apiCfg.Passwords.Argon2) {
+		return
+	}
+
+	hashed_password, err := apiCfg.Passwords.hash(password)
+	if err != nil {
+		log.Printf("Error re-hashing password for user %s: %s", user.ID, err)
+		return
+	}
+
+	err = apiCfg.DB.UpdateUserPassword(ctx, database.UpdateUserPasswordParams{
+		ID:             user.ID,
+		HashedPassword: hashed_password,
+	})
+	if err != nil {
+		log.Printf("Error storing re-hashed password for user %s: %s", user.ID, err)
+	}
+}
+
+// completeLogin starts a new session for user and responds with the access
+// and refresh tokens for it. The access token lasts accessTTL. A login with
+// ?session=cookie gets the tokens as cookies instead, for the web frontend.
+func (apiCfg *apiConfig) completeLogin(w http.ResponseWriter, r *http.Request, user database.User, accessTTL time.Duration) {
+	sessionID := uuid.New()
+	refresh_token, err := apiCfg.issueRefreshToken(r, user.ID, sessionID, uuid.NullUUID{}, auth.DefaultScopes)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error creating refresh token")
+		return
+	}
+
+	if wantsCookieSession(r) {
+		if err := apiCfg.setSessionCookies(w, user.ID, sessionID, auth.DefaultScopes, refresh_token, accessTTL); err != nil {
 			respondWithError(w, http.StatusInternalServerError, "Error creating JWT token")
 			return
 		}
+		response := databaseUserWithAuth(apiCfg.userResponse(r.Context(), user))
+		response.ExpiresInSeconds = int64(accessTTL.Seconds())
+		respondWithJSON(w, 200, response)
+		return
+	}
 
-		refresh_token, err := auth.MakeRefreshToken()
-		if err != nil {
-			respondWithError(w, http.StatusInternalServerError, err.Error())
+	accessToken, err := auth.MakeJWT(user.ID, apiCfg.Keys, sessionID, accessTTL, auth.DefaultScopes)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error creating JWT token")
+		return
+	}
+
+	response := databaseUserWithAuth(apiCfg.userResponse(r.Context(), user), accessToken, refresh_token)
+	response.ExpiresInSeconds = int64(accessTTL.Seconds())
+	respondWithJSON(w, 200, response)
+}
+
+// issueRefreshToken creates a new refresh token in the given family and
+// stores only its hash, along with the device and OAuth client it was
+// issued to and the scopes its access tokens carry.
+func (apiCfg *apiConfig) issueRefreshToken(r *http.Request, userID, familyID uuid.UUID, clientID uuid.NullUUID, scopes []string) (string, error) {
+	refreshToken, err := auth.MakeRefreshToken()
+	if err != nil {
+		return "", err
+	}
+
+	_, err = apiCfg.DB.CreateRefreshToken(r.Context(), database.CreateRefreshTokenParams{
+		TokenHash: auth.HashRefreshToken(refreshToken),
+		UserID:    userID,
+		ExpiresAt: time.Now().Add(apiCfg.Tokens.RefreshTTL),
+		RevokedAt: sql.NullTime{Valid: false},
+		FamilyID:  familyID,
+		UserAgent: r.UserAgent(),
+		IpAddress: clientIP(r),
+		ClientID:  clientID,
+		Scope:     auth.FormatScope(scopes),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return refreshToken, nil
+}
+
+var (
+	errInvalidRefreshToken = errors.New("Invalid refresh token")
+	errRefreshTokenExpired = errors.New("Refresh token has expired")
+)
+
+// handlerRefresh exchanges a refresh token from a password login for a new
+// access token and a new refresh token. Cookie sessions send the refresh
+// token as a cookie and get the new tokens back the same way.
+func (apiCfg *apiConfig) handlerRefresh(w http.ResponseWriter, r *http.Request) {
+	token, fromCookie, err := refreshTokenFromRequest(r)
+	if errors.Is(err, errInvalidCSRFToken) {
+		respondWithError(w, http.StatusForbidden, err.Error())
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Authorization token is missing or invalid")
+		return
+	}
+
+	stored, newRefreshToken, err := apiCfg.rotateRefreshToken(r, token, uuid.NullUUID{})
+	if errors.Is(err, errInvalidRefreshToken) || errors.Is(err, errRefreshTokenExpired) {
+		if fromCookie {
+			apiCfg.clearSessionCookies(w)
+		}
+		respondWithError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Could not refresh token")
+		return
+	}
+
+	scopes := auth.ParseScope(stored.Scope)
+	if fromCookie {
+		if err := apiCfg.setSessionCookies(w, stored.UserID, stored.FamilyID, scopes, newRefreshToken, apiCfg.Tokens.AccessTTL); err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Error creating JWT token")
 			return
 		}
-		apiCfg.DB.CreateRefreshToken(r.Context(), database.CreateRefreshTokenParams{
-			Token:     refresh_token,
-			UserID:    user.ID,
-			ExpiresAt: time.Now().Add(time.Hour * 24 * 60),
-			RevokedAt: sql.NullTime{Valid: false},
+		respondWithJSON(w, 200, map[string]any{
+			"expires_in_seconds": int64(apiCfg.Tokens.AccessTTL.Seconds()),
 		})
+		return
+	}
 
-		respondWithJSON(w, 200, databaseUserWithAuth(user, accessToken, refresh_token))
-	} else {
-		fmt.Println(err)
-		respondWithError(w, http.StatusUnauthorized, "unauthorized")
+	accessToken, err := auth.MakeJWT(stored.UserID, apiCfg.Keys, stored.FamilyID, apiCfg.Tokens.AccessTTL, scopes)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Error creating JWT token")
 		return
 	}
+
+	respondWithJSON(w, 200, map[string]any{
+		"token":              accessToken,
+		"refresh_token":      newRefreshToken,
+		"expires_in_seconds": int64(apiCfg.Tokens.AccessTTL.Seconds()),
+	})
 }
 
-func (apiCfg *apiConfig) handlerRefresh(w http.ResponseWriter, r *http.Request) {
+// refreshTokenFromRequest returns the refresh token in the Authorization
+// header, or else the one in a cookie session, and whether it came from the
+// cookie.
+func refreshTokenFromRequest(r *http.Request) (string, bool, error) {
+	if r.Header.Get("Authorization") == "" {
+		if _, err := r.Cookie(refreshTokenCookie); err == nil {
+			token, err := refreshTokenFromCookie(r)
+			return token, true, err
+		}
+	}
+
 	token, err := auth.GetBearerToken(r.Header)
+	return token, false, err
+}
+
+// rotateRefreshToken revokes token and issues its replacement in the same
+// family and transaction, returning the revoked token's record. clientID
+// must be the OAuth client the token was issued to, or null for a password
+// login. A token that was already rotated is being replayed, most likely
+// because it leaked, so its whole family is revoked and the caller has to
+// log in again.
+func (apiCfg *apiConfig) rotateRefreshToken(r *http.Request, token string, clientID uuid.NullUUID) (database.RefreshToken, string, error) {
+	tokenHash := auth.HashRefreshToken(token)
+
+	stored, err := apiCfg.DB.GetRefreshToken(r.Context(), tokenHash)
+	if errors.Is(err, sql.ErrNoRows) {
+		return database.RefreshToken{}, "", errInvalidRefreshToken
+	}
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, `"error": "Authorization token is missing or invalid"`)
-		return
+		return database.RefreshToken{}, "", err
 	}
 
-	user, err := apiCfg.DB.GetUserFromRefreshToken(r.Context(), token)
+	if stored.ClientID != clientID {
+		return database.RefreshToken{}, "", errInvalidRefreshToken
+	}
+
+	if stored.RevokedAt.Valid {
+		if stored.ReplacedBy.Valid {
+			apiCfg.revokeTokenFamily(r.Context(), stored)
+		}
+		return database.RefreshToken{}, "", errInvalidRefreshToken
+	}
 
+	if time.Now().After(stored.ExpiresAt) {
+		return database.RefreshToken{}, "", errRefreshTokenExpired
+	}
+
+	tx, err := apiCfg.DBConn.BeginTx(r.Context(), nil)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, err.Error())
-		return
+		return database.RefreshToken{}, "", err
 	}
+	defer tx.Rollback()
+	qtx := apiCfg.DB.WithTx(tx)
 
-	accessToken, err := auth.MakeJWT(user.ID, apiCfg.Secret)
+	newRefreshToken, err := auth.MakeRefreshToken()
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Error creating JWT token")
-		return
+		return database.RefreshToken{}, "", err
+	}
+
+	rotated, err := qtx.RotateRefreshToken(r.Context(), database.RotateRefreshTokenParams{
+		TokenHash:  tokenHash,
+		ReplacedBy: sql.NullString{String: auth.HashRefreshToken(newRefreshToken), Valid: true},
+	})
+	if err != nil {
+		return database.RefreshToken{}, "", err
+	}
+	if rotated == 0 {
+		// Another request rotated this token between our read and write.
+		tx.Rollback()
+		apiCfg.revokeTokenFamily(r.Context(), stored)
+		return database.RefreshToken{}, "", errInvalidRefreshToken
 	}
 
-	respondWithJSON(w, 200, map[string]string{
-		"token": accessToken,
+	_, err = qtx.CreateRefreshToken(r.Context(), database.CreateRefreshTokenParams{
+		TokenHash: auth.HashRefreshToken(newRefreshToken),
+		UserID:    stored.UserID,
+		ExpiresAt: time.Now().Add(apiCfg.Tokens.RefreshTTL),
+		RevokedAt: sql.NullTime{Valid: false},
+		FamilyID:  stored.FamilyID,
+		UserAgent: r.UserAgent(),
+		IpAddress: clientIP(r),
+		ClientID:  stored.ClientID,
+		Scope:     stored.Scope,
 	})
+	if err != nil {
+		return database.RefreshToken{}, "", err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return database.RefreshToken{}, "", err
+	}
+
+	return stored, newRefreshToken, nil
+}
+
+func (apiCfg *apiConfig) revokeTokenFamily(ctx context.Context, stored database.RefreshToken) {
+	log.Printf("Refresh token reuse detected for user %s, revoking token family %s", stored.UserID, stored.FamilyID)
+	if err := apiCfg.DB.RevokeTokenFamily(ctx, stored.FamilyID); err != nil {
+		log.Printf("Error revoking token family %s: %s", stored.FamilyID, err)
+	}
 }
 
 func (apiCfg *apiConfig) handlerRevoke(w http.ResponseWriter, r *http.Request) {
-	token, err := auth.GetBearerToken(r.Header)
+	token, fromCookie, err := refreshTokenFromRequest(r)
+	if errors.Is(err, errInvalidCSRFToken) {
+		respondWithError(w, http.StatusForbidden, err.Error())
+		return
+	}
 	if err != nil {
 		respondWithError(w, http.StatusUnauthorized, `"error": "Authorization token is missing or invalid"`)
 		return
 	}
 
-	err = apiCfg.DB.RevokeToken(r.Context(), token)
+	err = apiCfg.DB.RevokeToken(r.Context(), auth.HashRefreshToken(token))
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
 
+	if fromCookie {
+		apiCfg.clearSessionCookies(w)
+	}
+
 	w.WriteHeader(http.StatusNoContent)
 }
 
 func (apiCfg *apiConfig) handlerUpdateCredentials(w http.ResponseWriter, r *http.Request) {
 	type parameters struct {
-		Email    string `json:"email"`
-		Password string `json:"password"`
+		Email               string `json:"email"`
+		Password            string `json:"password"`
+		RevokeOtherSessions bool   `json:"revoke_other_sessions"`
 	}
 
 	params := parameters{}
@@ -319,19 +757,27 @@ func (apiCfg *apiConfig) handlerUpdateCredentials(w http.ResponseWriter, r *http
 		respondWithJSON(w, http.StatusInternalServerError, []byte(`{"error": "Something went wrong"}`))
 	}
 
-	token, err := auth.GetBearerToken(r.Header)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, `"error": "Authorization token is missing or invalid"`)
+	subject := authenticatedSubject(r)
+	if !requireScope(w, subject, auth.ScopeAccount) {
 		return
 	}
 
-	userId, err := auth.ValidateJWT(token, apiCfg.Secret)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Invalid or expired token")
+	if !authorize(w, subject, authz.ActionEdit, authz.Resource{Kind: authz.ResourceUser, OwnerID: subject.UserID}) {
+		return
+	}
+	userId := subject.UserID
+
+	if err := validateEmail(params.Email); err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err := apiCfg.Passwords.Policy.Check(params.Password, params.Email); err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
 		return
 	}
 
-	hashed_password, err := auth.HashPassword(params.Password)
+	hashed_password, err := apiCfg.Passwords.hash(params.Password)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Error processing password")
 		return
@@ -347,7 +793,24 @@ func (apiCfg *apiConfig) handlerUpdateCredentials(w http.ResponseWriter, r *http
 		return
 	}
 
-	respondWithJSON(w, http.StatusOK, databaseUserToUser(user))
+	// Changing the address drops the verified flag, so the new address has
+	// to be confirmed before the user can post again.
+	if !user.Verified {
+		apiCfg.sendVerificationEmail(r.Context(), user)
+	}
+
+	if params.RevokeOtherSessions {
+		err = apiCfg.DB.RevokeOtherUserSessions(r.Context(), database.RevokeOtherUserSessionsParams{
+			UserID:   userId,
+			FamilyID: subject.SessionID,
+		})
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Could not revoke other sessions")
+			return
+		}
+	}
+
+	respondWithJSON(w, http.StatusOK, apiCfg.userResponse(r.Context(), user))
 }
 
 func (apiCfg *apiConfig) handlerDeleteChirp(w http.ResponseWriter, r *http.Request) {
@@ -359,40 +822,46 @@ func (apiCfg *apiConfig) handlerDeleteChirp(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	token, err := auth.GetBearerToken(r.Header)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, `"error": "Authorization token is missing or invalid"`)
-		return
-	}
+	subject := authenticatedSubject(r)
 
-	_, err = auth.ValidateJWT(token, apiCfg.Secret)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Invalid or expired token")
+	chirp, err := apiCfg.DB.GetChirp(r.Context(), parsedChirpId)
+	if err != nil || chirp.DeletedAt.Valid {
+		respondWithError(w, http.StatusNotFound, "Chirp not found")
 		return
 	}
 
-	_, err = apiCfg.DB.GetChirp(r.Context(), parsedChirpId)
-	if err != nil {
-		respondWithError(w, http.StatusNotFound, err.Error())
+	if !authorize(w, subject, authz.ActionDelete, authz.Resource{Kind: authz.ResourceChirp, OwnerID: chirp.UserID}) {
 		return
 	}
 
-	err = apiCfg.DB.DeleteChirp(r.Context(), parsedChirpId)
+	// Chirps are only ever soft-deleted so that replies keep their place in
+	// the thread and render under a placeholder.
+	deleted, err := apiCfg.DB.SoftDeleteChirp(r.Context(), parsedChirpId)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
+	apiCfg.publishEvent(r.Context(), eventChirpDeleted, databaseChirpToDeletion(deleted))
+	apiCfg.publishWebhookEvent(r.Context(), webhookChirpDeleted, uuid.NullUUID{}, map[string]uuid.UUID{
+		"id":      chirp.ID,
+		"user_id": chirp.UserID,
+	})
 
 	respondWithJSON(w, http.StatusNoContent, nil)
 }
 
-func (apiCfg *apiConfig) handlerPolkaWebhook(w http.ResponseWriter, r *http.Request) {
-	type UserData struct {
-		UserId uuid.UUID `json:"user_id"`
-	}
+// handlerUpdateChirp replaces the body of a chirp, if its author's plan lets
+// them edit chirps. Moderators editing someone else's chirp are held to the
+// author's limits, not their own. Media attachments stay as they were.
+func (apiCfg *apiConfig) handlerUpdateChirp(w http.ResponseWriter, r *http.Request) {
 	type parameters struct {
-		Event string   `json:"event"`
-		Data  UserData `json:"data"`
+		Body string `json:"body"`
+	}
+
+	parsedChirpId, err := uuid.Parse(r.PathValue("chirpID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid chirp ID")
+		return
 	}
 
 	params := parameters{}
@@ -402,33 +871,67 @@ func (apiCfg *apiConfig) handlerPolkaWebhook(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	apiKey, err := auth.GetAPIKey(r.Header)
+	subject := authenticatedSubject(r)
+
+	chirp, err := apiCfg.DB.GetChirp(r.Context(), parsedChirpId)
+	if err != nil || chirp.DeletedAt.Valid {
+		respondWithError(w, http.StatusNotFound, "Chirp not found")
+		return
+	}
+
+	if !authorize(w, subject, authz.ActionEdit, authz.Resource{Kind: authz.ResourceChirp, OwnerID: chirp.UserID}) {
+		return
+	}
+
+	limits, err := apiCfg.entitlementsFor(r.Context(), chirp.UserID)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, err.Error())
+		respondWithError(w, http.StatusInternalServerError, "Failed to update chirp")
+		return
+	}
+	if !limits.CanEditChirps {
+		respondWithError(w, http.StatusForbidden, errChirpEditingNotPaid.Error())
+		return
+	}
+	if utf8.RuneCountInString(params.Body) > limits.MaxChirpLength {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Chirp is too long, the limit is %d characters", limits.MaxChirpLength))
 		return
 	}
 
-	if apiKey != apiCfg.PolkaSecret {
-		respondWithError(w, http.StatusUnauthorized, "Invalid Api Key")
+	updated, err := apiCfg.DB.UpdateChirpBody(r.Context(), database.UpdateChirpBodyParams{
+		ID:   chirp.ID,
+		Body: censorString(params.Body),
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, http.StatusNotFound, "Chirp not found")
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to update chirp")
 		return
 	}
 
-	if params.Event == "user.upgraded" {
-		user, err := apiCfg.DB.GetUserFromId(r.Context(), params.Data.UserId)
-		if err != nil {
-			respondWithError(w, http.StatusNotFound, "User not found")
-			return
-		}
+	respondWithJSON(w, http.StatusOK, databaseChirpToChirp(updated))
+}
 
-		err = apiCfg.DB.UpgradeUser(r.Context(), user.ID)
-		if err != nil {
-			respondWithError(w, http.StatusInternalServerError, "Could not upgrade user")
-			return
+// mailerFromEnv picks the mail transport. MAILER=smtp sends real mail;
+// anything else writes messages to MAIL_DIR, or to the log if that is unset.
+func mailerFromEnv() mailer.Mailer {
+	from := os.Getenv("MAIL_FROM")
+	if from == "" {
+		from = "Chirpy <no-reply@chirpy.local>"
+	}
+
+	if os.Getenv("MAILER") == "smtp" {
+		return &mailer.SMTPMailer{
+			Host:     os.Getenv("SMTP_HOST"),
+			Port:     os.Getenv("SMTP_PORT"),
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+			From:     from,
 		}
-		respondWithJSON(w, http.StatusNoContent, nil)
-	} else {
-		respondWithJSON(w, http.StatusNoContent, nil)
 	}
+
+	return &mailer.LogMailer{Dir: os.Getenv("MAIL_DIR"), From: from}
 }
 
 func main() {
@@ -445,35 +948,149 @@ func main() {
 	}
 	mux := http.NewServeMux()
 
-	secret := os.Getenv("SECRET")
-	polkaSecret := os.Getenv("POLKA_KEY")
+	tokens, err := tokenConfigFromEnv()
+	if err != nil {
+		log.Fatal("invalid token configuration: ", err)
+	}
+	passwords, err := passwordConfigFromEnv()
+	if err != nil {
+		log.Fatal("invalid password configuration: ", err)
+	}
+	keys, err := keyringFromEnv(tokens)
+	if err != nil {
+		log.Fatal("can't load JWT signing keys: ", err)
+	}
+	polka, err := polkaConfigFromEnv()
+	if err != nil {
+		log.Fatal("invalid Polka configuration: ", err)
+	}
+	baseURL := os.Getenv("BASE_URL")
+	if baseURL == "" {
+		baseURL = "http://localhost:" + port
+	}
+	baseURL = strings.TrimSuffix(baseURL, "/")
+	oidcProviders, err := oidcProvidersFromEnv(baseURL, keys.Leeway)
+	if err != nil {
+		log.Fatal("invalid OIDC configuration: ", err)
+	}
+	entitlementsTTL, err := durationFromEnv("ENTITLEMENTS_CACHE_TTL", time.Minute)
+	if err != nil {
+		log.Fatal("invalid ENTITLEMENTS_CACHE_TTL: ", err)
+	}
 	apiCfg := apiConfig{
-		DB:          dbQueries,
-		Secret:      secret,
-		PolkaSecret: polkaSecret,
+		DB:        dbQueries,
+		DBConn:    db,
+		Keys:      keys,
+		Tokens:    tokens,
+		Passwords: passwords,
+		Polka:     polka,
+		Mailer:    mailerFromEnv(),
+		BaseURL:   baseURL,
+		OIDC:      oidcProviders,
+	}
+	apiCfg.Entitlements = entitlements.NewEngine(apiCfg.loadPlan, entitlementsTTL)
+	apiCfg.RateLimiter = ratelimit.New(time.Minute)
+	apiCfg.Webhooks = newWebhookDispatcher(os.Getenv("PLATFORM") == "DEV")
+	apiCfg.Stream = stream.NewHub()
+	apiCfg.Events, err = eventBusFromEnv(dbQueries, dbUrl)
+	if err != nil {
+		log.Fatal("can't start the event bus: ", err)
 	}
+	apiCfg.Events.Subscribe(apiCfg.handleEvent)
 
 	mux.Handle("/app/", http.StripPrefix("/app/", apiCfg.middlewareMetricsInc(http.FileServer(http.Dir(".")))))
 
 	mux.HandleFunc("GET /api/healthz", handlerReadiness)
+	mux.HandleFunc("GET /.well-known/jwks.json", apiCfg.handlerJWKS)
 
 	mux.HandleFunc("GET /admin/metrics", apiCfg.handlerMetris)
 	mux.HandleFunc("POST /admin/reset", apiCfg.resetMetrics)
+	mux.Handle("PUT /admin/users/{userID}/role", apiCfg.middlewareAuthenticate(apiCfg.handlerUpdateUserRole))
+	mux.Handle("GET /admin/plans", apiCfg.middlewareAuthenticate(apiCfg.handlerListPlans))
+	mux.Handle("PUT /admin/plans/{plan}", apiCfg.middlewareAuthenticate(apiCfg.handlerUpdatePlan))
 
 	mux.HandleFunc("POST /api/login", apiCfg.handlerLogin)
+	mux.HandleFunc("POST /api/login/2fa", apiCfg.handlerLoginTwoFactor)
+	mux.HandleFunc("POST /api/login/magic", apiCfg.handlerRequestMagicLink)
+	mux.HandleFunc("GET /api/login/magic/verify", apiCfg.handlerShowMagicLink)
+	mux.HandleFunc("POST /api/login/magic/verify", apiCfg.handlerMagicLinkLogin)
+	mux.Handle("POST /api/2fa/enroll", apiCfg.middlewareAuthenticate(apiCfg.handlerEnrollTOTP))
+	mux.Handle("POST /api/2fa/confirm", apiCfg.middlewareAuthenticate(apiCfg.handlerConfirmTOTP))
+	mux.Handle("DELETE /api/2fa", apiCfg.middlewareAuthenticate(apiCfg.handlerDisableTOTP))
+	mux.HandleFunc("GET /api/oidc/providers", apiCfg.handlerListOIDCProviders)
+	mux.HandleFunc("GET /api/oidc/{provider}/login", apiCfg.handlerOIDCLogin)
+	mux.HandleFunc("GET /api/oidc/{provider}/callback", apiCfg.handlerOIDCCallback)
 	mux.HandleFunc("POST /api/users", apiCfg.handlerCreateUser)
+	mux.HandleFunc("GET /api/users/verify", apiCfg.handlerVerifyEmail)
+	mux.Handle("POST /api/users/verify", apiCfg.middlewareAuthenticate(apiCfg.handlerResendVerification))
 
 	mux.HandleFunc("GET /api/chirps/{chirpID}", apiCfg.handlerGetChirp)
+	mux.HandleFunc("GET /api/chirps/{chirpID}/thread", apiCfg.handlerGetThread)
 	mux.HandleFunc("GET /api/chirps", apiCfg.handlerGetChirps)
-	mux.HandleFunc("POST /api/chirps", apiCfg.handlerValidateChirp)
+	mux.Handle("POST /api/chirps", apiCfg.middlewareAuthenticate(apiCfg.handlerValidateChirp))
+
+	mux.HandleFunc("POST /api/password/forgot", apiCfg.handlerForgotPassword)
+	mux.HandleFunc("POST /api/password/reset", apiCfg.handlerResetPassword)
 
 	mux.HandleFunc("POST /api/refresh", apiCfg.handlerRefresh)
 	mux.HandleFunc("POST /api/revoke", apiCfg.handlerRevoke)
 
-	mux.HandleFunc("PUT /api/users", apiCfg.handlerUpdateCredentials)
-	mux.HandleFunc("DELETE /api/chirps/{chirpID}", apiCfg.handlerDeleteChirp)
+	mux.Handle("GET /api/sessions", apiCfg.middlewareAuthenticate(apiCfg.handlerListSessions))
+	mux.Handle("DELETE /api/sessions", apiCfg.middlewareAuthenticate(apiCfg.handlerRevokeAllSessions))
+	mux.Handle("DELETE /api/sessions/{sessionID}", apiCfg.middlewareAuthenticate(apiCfg.handlerRevokeSession))
+
+	mux.Handle("POST /api/oauth/clients", apiCfg.middlewareAuthenticate(apiCfg.handlerCreateOAuthClient))
+	mux.Handle("GET /api/oauth/clients", apiCfg.middlewareAuthenticate(apiCfg.handlerListOAuthClients))
+	mux.Handle("DELETE /api/oauth/clients/{clientID}", apiCfg.middlewareAuthenticate(apiCfg.handlerDeleteOAuthClient))
+
+	mux.HandleFunc("GET /.well-known/oauth-authorization-server", apiCfg.handlerOAuthMetadata)
+	mux.HandleFunc("GET /oauth/authorize", apiCfg.handlerAuthorize)
+	mux.HandleFunc("POST /oauth/authorize", apiCfg.handlerApproveAuthorization)
+	mux.HandleFunc("POST /oauth/token", apiCfg.handlerToken)
+	mux.HandleFunc("POST /oauth/introspect", apiCfg.handlerIntrospect)
+	mux.HandleFunc("POST /oauth/revoke", apiCfg.handlerOAuthRevoke)
+
+	mux.Handle("POST /api/tokens", apiCfg.middlewareAuthenticate(apiCfg.handlerCreateToken))
+	mux.Handle("GET /api/tokens", apiCfg.middlewareAuthenticate(apiCfg.handlerListTokens))
+	mux.Handle("DELETE /api/tokens/{tokenID}", apiCfg.middlewareAuthenticate(apiCfg.handlerRevokeToken))
+
+	mux.Handle("PUT /api/users", apiCfg.middlewareAuthenticate(apiCfg.handlerUpdateCredentials))
+	mux.Handle("PATCH /api/users", apiCfg.middlewareAuthenticate(apiCfg.handlerUpdateProfile))
+	mux.HandleFunc("GET /api/users/{handle}", apiCfg.handlerGetProfile)
+	mux.Handle("PUT /api/chirps/{chirpID}", apiCfg.middlewareAuthenticate(apiCfg.handlerUpdateChirp))
+	mux.Handle("DELETE /api/chirps/{chirpID}", apiCfg.middlewareAuthenticate(apiCfg.handlerDeleteChirp))
 
 	mux.HandleFunc("POST /api/polka/webhooks", apiCfg.handlerPolkaWebhook)
+	mux.Handle("GET /api/subscription", apiCfg.middlewareAuthenticate(apiCfg.handlerGetSubscription))
+	mux.Handle("GET /api/entitlements", apiCfg.middlewareAuthenticate(apiCfg.handlerGetEntitlements))
+
+	mux.Handle("POST /api/webhooks", apiCfg.middlewareAuthenticate(apiCfg.handlerCreateWebhook))
+	mux.Handle("GET /api/webhooks", apiCfg.middlewareAuthenticate(apiCfg.handlerListWebhooks))
+	mux.Handle("DELETE /api/webhooks/{webhookID}", apiCfg.middlewareAuthenticate(apiCfg.handlerDeleteWebhook))
+	mux.Handle("GET /api/webhooks/{webhookID}/deliveries", apiCfg.middlewareAuthenticate(apiCfg.handlerListWebhookDeliveries))
+	mux.Handle("POST /api/webhooks/{webhookID}/deliveries/{deliveryID}/redeliver", apiCfg.middlewareAuthenticate(apiCfg.handlerRedeliverWebhook))
+
+	mux.Handle("POST /api/users/{userID}/follow", apiCfg.middlewareAuthenticate(apiCfg.handlerFollowUser))
+	mux.Handle("DELETE /api/users/{userID}/follow", apiCfg.middlewareAuthenticate(apiCfg.handlerUnfollowUser))
+	mux.HandleFunc("GET /api/users/{userID}/followers", apiCfg.handlerGetFollowers)
+	mux.HandleFunc("GET /api/users/{userID}/following", apiCfg.handlerGetFollowing)
+	mux.Handle("GET /api/timeline", apiCfg.middlewareAuthenticate(apiCfg.handlerGetTimeline))
+	mux.Handle("GET /api/stream", apiCfg.middlewareAuthenticate(apiCfg.handlerStreamChirps))
+	mux.Handle("GET /api/stream/ws", apiCfg.middlewareAuthenticate(apiCfg.handlerStreamChirpsWebSocket))
+
+	expiryInterval, err := durationFromEnv("SUBSCRIPTION_EXPIRY_INTERVAL", time.Minute*10)
+	if err != nil || expiryInterval <= 0 {
+		log.Fatal("invalid SUBSCRIPTION_EXPIRY_INTERVAL: ", err)
+	}
+	go apiCfg.runSubscriptionExpiry(context.Background(), expiryInterval)
+
+	webhookInterval, err := durationFromEnv("WEBHOOK_POLL_INTERVAL", time.Second*30)
+	if err != nil || webhookInterval <= 0 {
+		log.Fatal("invalid WEBHOOK_POLL_INTERVAL: ", err)
+	}
+	go apiCfg.runWebhookDeliveries(context.Background(), webhookInterval)
+
+	go apiCfg.runMetricsFlush(context.Background(), metricsFlushInterval)
 
 	srv := http.Server{
 		Handler: mux,
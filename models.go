@@ -2,48 +2,115 @@ package main
 
 import (
 	"chirpy/internal/database"
-	"database/sql"
 	"time"
 
 	"github.com/google/uuid"
 )
 
 type User struct {
-	ID          uuid.UUID    `json:"id"`
-	CreatedAt   time.Time    `json:"created_at"`
-	UpdatedAt   time.Time    `json:"updated_at"`
-	Email       string       `json:"email"`
-	IsChirpyRed sql.NullBool `json:"is_chirpy_red"`
+	ID          uuid.UUID `json:"id"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+	Email       string    `json:"email"`
+	IsChirpyRed bool      `json:"is_chirpy_red"`
+	Handle      string    `json:"handle"`
+	DisplayName string    `json:"display_name"`
+	Bio         string    `json:"bio"`
+	AvatarURL   string    `json:"avatar_url"`
+	Role        string    `json:"role"`
+	Verified    bool      `json:"verified"`
+}
+
+// Profile is the public view of a user. It must never carry the email or
+// anything else that is only meant for the account owner.
+type Profile struct {
+	ID          uuid.UUID `json:"id"`
+	CreatedAt   time.Time `json:"created_at"`
+	Handle      string    `json:"handle"`
+	DisplayName string    `json:"display_name"`
+	Bio         string    `json:"bio"`
+	AvatarURL   string    `json:"avatar_url"`
+}
+
+// AuthorSummary is the part of a profile embedded in chirps.
+type AuthorSummary struct {
+	ID          uuid.UUID `json:"id"`
+	Handle      string    `json:"handle"`
+	DisplayName string    `json:"display_name"`
+	AvatarURL   string    `json:"avatar_url"`
 }
 
 type AuthResponse struct {
 	User
-	AccessToken  string `json:"token"`
-	RefreshToken string `json:"refresh_token"`
+	AccessToken      string `json:"token,omitempty"`
+	RefreshToken     string `json:"refresh_token,omitempty"`
+	ExpiresInSeconds int64  `json:"expires_in_seconds,omitempty"`
 }
 
 type Chirp struct {
-	ID        uuid.UUID `json:"id"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
-	Body      string    `json:"body"`
-	UserID    uuid.UUID `json:"user_id"`
+	ID        uuid.UUID      `json:"id"`
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+	Body      string         `json:"body"`
+	UserID    uuid.UUID      `json:"user_id"`
+	InReplyTo *uuid.UUID     `json:"in_reply_to,omitempty"`
+	MediaURLs []string       `json:"media_urls"`
+	Deleted   bool           `json:"deleted,omitempty"`
+	Author    *AuthorSummary `json:"author,omitempty"`
+}
+
+type ThreadChirp struct {
+	Chirp
+	Replies []ThreadChirp `json:"replies"`
 }
 
+// FollowUser is one entry in a follower or following list.
+type FollowUser struct {
+	UserID     uuid.UUID `json:"user_id"`
+	FollowedAt time.Time `json:"followed_at"`
+}
+
+const deletedChirpBody = "[deleted]"
+
 func databaseUserToUser(dbUser database.User) User {
 	user := User{
 		ID:          dbUser.ID,
 		CreatedAt:   dbUser.CreatedAt,
 		UpdatedAt:   dbUser.UpdatedAt,
 		Email:       dbUser.Email,
-		IsChirpyRed: dbUser.IsChirpyRed,
+		Handle:      dbUser.Handle,
+		DisplayName: dbUser.DisplayName,
+		Bio:         dbUser.Bio,
+		AvatarURL:   dbUser.AvatarUrl,
+		Role:        dbUser.Role,
+		Verified:    dbUser.Verified,
 	}
 	return user
 }
 
-func databaseUserWithAuth(dbUser database.User, tokens ...string) AuthResponse {
+func databaseUserToProfile(dbUser database.User) Profile {
+	return Profile{
+		ID:          dbUser.ID,
+		CreatedAt:   dbUser.CreatedAt,
+		Handle:      dbUser.Handle,
+		DisplayName: dbUser.DisplayName,
+		Bio:         dbUser.Bio,
+		AvatarURL:   dbUser.AvatarUrl,
+	}
+}
+
+func databaseAuthorToAuthorSummary(dbAuthor database.GetAuthorsRow) AuthorSummary {
+	return AuthorSummary{
+		ID:          dbAuthor.ID,
+		Handle:      dbAuthor.Handle,
+		DisplayName: dbAuthor.DisplayName,
+		AvatarURL:   dbAuthor.AvatarUrl,
+	}
+}
+
+func databaseUserWithAuth(user User, tokens ...string) AuthResponse {
 	authResponse := AuthResponse{
-		User: databaseUserToUser(dbUser),
+		User: user,
 	}
 
 	if len(tokens) > 0 {
@@ -60,13 +127,25 @@ func databaseUserWithAuth(dbUser database.User, tokens ...string) AuthResponse {
 }
 
 func databaseChirpToChirp(dbChirp database.Chirp) Chirp {
-	return Chirp{
+	chirp := Chirp{
 		ID:        dbChirp.ID,
 		CreatedAt: dbChirp.CreatedAt,
 		UpdatedAt: dbChirp.UpdatedAt,
 		Body:      dbChirp.Body,
 		UserID:    dbChirp.UserID,
+		MediaURLs: dbChirp.MediaUrls,
+	}
+	if chirp.MediaURLs == nil {
+		chirp.MediaURLs = []string{}
+	}
+	if dbChirp.ParentID.Valid {
+		chirp.InReplyTo = &dbChirp.ParentID.UUID
 	}
+	if dbChirp.DeletedAt.Valid {
+		chirp.Body = deletedChirpBody
+		chirp.Deleted = true
+	}
+	return chirp
 }
 
 func databaseChirpsToChirps(dbChirp []database.Chirp) []Chirp {
@@ -76,3 +155,74 @@ func databaseChirpsToChirps(dbChirp []database.Chirp) []Chirp {
 	}
 	return chirps
 }
+
+// databaseChirpsToThread nests a conversation, given in (created_at, id)
+// order, under its root chirp. Replies whose parent is missing from the
+// conversation are attached to the root so they are never dropped.
+func databaseChirpsToThread(rootID uuid.UUID, dbChirps []database.Chirp) (ThreadChirp, bool) {
+	children := map[uuid.UUID][]database.Chirp{}
+	var root *database.Chirp
+	known := map[uuid.UUID]bool{}
+	for i, dbChirp := range dbChirps {
+		known[dbChirp.ID] = true
+		if dbChirp.ID == rootID {
+			root = &dbChirps[i]
+		}
+	}
+	if root == nil {
+		return ThreadChirp{}, false
+	}
+
+	for _, dbChirp := range dbChirps {
+		if dbChirp.ID == rootID {
+			continue
+		}
+		parentID := rootID
+		if dbChirp.ParentID.Valid && known[dbChirp.ParentID.UUID] {
+			parentID = dbChirp.ParentID.UUID
+		}
+		children[parentID] = append(children[parentID], dbChirp)
+	}
+
+	var build func(dbChirp database.Chirp) ThreadChirp
+	build = func(dbChirp database.Chirp) ThreadChirp {
+		node := ThreadChirp{
+			Chirp:   databaseChirpToChirp(dbChirp),
+			Replies: []ThreadChirp{},
+		}
+		for _, child := range children[dbChirp.ID] {
+			node.Replies = append(node.Replies, build(child))
+		}
+		return node
+	}
+
+	return build(*root), true
+}
+
+func chirpCursor(dbChirp database.Chirp) cursor {
+	return cursor{CreatedAt: dbChirp.CreatedAt, ID: dbChirp.ID}
+}
+
+func databaseFollowsToFollowers(dbFollows []database.Follow) []FollowUser {
+	followers := []FollowUser{}
+	for _, dbFollow := range dbFollows {
+		followers = append(followers, FollowUser{UserID: dbFollow.FollowerID, FollowedAt: dbFollow.CreatedAt})
+	}
+	return followers
+}
+
+func databaseFollowsToFollowing(dbFollows []database.Follow) []FollowUser {
+	following := []FollowUser{}
+	for _, dbFollow := range dbFollows {
+		following = append(following, FollowUser{UserID: dbFollow.FolloweeID, FollowedAt: dbFollow.CreatedAt})
+	}
+	return following
+}
+
+func followerCursor(dbFollow database.Follow) cursor {
+	return cursor{CreatedAt: dbFollow.CreatedAt, ID: dbFollow.FollowerID}
+}
+
+func followeeCursor(dbFollow database.Follow) cursor {
+	return cursor{CreatedAt: dbFollow.CreatedAt, ID: dbFollow.FolloweeID}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	defaultPageLimit = 50
+	maxPageLimit     = 100
+)
+
+// cursor points at a single row in a list ordered by (created_at, id).
+// Clients only ever see it in its opaque, encoded form.
+type cursor struct {
+	CreatedAt time.Time
+	ID        uuid.UUID
+}
+
+func (c cursor) encode() string {
+	raw := strconv.FormatInt(c.CreatedAt.UnixMicro(), 10) + ":" + c.ID.String()
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeCursor(s string) (cursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return cursor{}, errors.New("invalid cursor")
+	}
+
+	micros, id, ok := strings.Cut(string(raw), ":")
+	if !ok {
+		return cursor{}, errors.New("invalid cursor")
+	}
+
+	usec, err := strconv.ParseInt(micros, 10, 64)
+	if err != nil {
+		return cursor{}, errors.New("invalid cursor")
+	}
+
+	parsedID, err := uuid.Parse(id)
+	if err != nil {
+		return cursor{}, errors.New("invalid cursor")
+	}
+
+	return cursor{CreatedAt: time.UnixMicro(usec).UTC(), ID: parsedID}, nil
+}
+
+// pageParams is the parsed form of the limit, before and after query
+// parameters shared by every paginated list endpoint.
+type pageParams struct {
+	Limit    int32
+	Cursor   *cursor
+	Backward bool
+}
+
+func parsePageParams(query url.Values) (pageParams, error) {
+	params := pageParams{Limit: defaultPageLimit}
+
+	if limitStr := query.Get("limit"); limitStr != "" {
+		limit, err := strconv.Atoi(limitStr)
+		if err != nil || limit < 1 {
+			return pageParams{}, errors.New("limit must be a positive integer")
+		}
+		params.Limit = int32(min(limit, maxPageLimit))
+	}
+
+	before, after := query.Get("before"), query.Get("after")
+	if before != "" && after != "" {
+		return pageParams{}, errors.New("only one of before and after may be set")
+	}
+
+	if after != "" {
+		c, err := decodeCursor(after)
+		if err != nil {
+			return pageParams{}, err
+		}
+		params.Cursor = &c
+	}
+
+	if before != "" {
+		c, err := decodeCursor(before)
+		if err != nil {
+			return pageParams{}, err
+		}
+		params.Cursor = &c
+		params.Backward = true
+	}
+
+	return params, nil
+}
+
+func (p pageParams) cursorTime() sql.NullTime {
+	if p.Cursor == nil {
+		return sql.NullTime{}
+	}
+	return sql.NullTime{Time: p.Cursor.CreatedAt, Valid: true}
+}
+
+func (p pageParams) cursorID() uuid.NullUUID {
+	if p.Cursor == nil {
+		return uuid.NullUUID{}
+	}
+	return uuid.NullUUID{UUID: p.Cursor.ID, Valid: true}
+}
+
+// pageFetcher loads up to limit rows past the cursor in p, in either
+// ascending or descending (created_at, id) order.
+type pageFetcher[T any] func(p pageParams, limit int32, ascending bool) ([]T, error)
+
+type page[T any] struct {
+	Items   []T
+	HasNext bool
+	HasPrev bool
+}
+
+// fetchPage loads one page of results in display order. The query always
+// walks away from the cursor, so a backward page is fetched in the opposite
+// order and reversed before it is returned.
+func fetchPage[T any](p pageParams, desc bool, fetch pageFetcher[T]) (page[T], error) {
+	ascending := p.Backward == desc
+
+	rows, err := fetch(p, p.Limit+1, ascending)
+	if err != nil {
+		return page[T]{}, err
+	}
+
+	more := len(rows) > int(p.Limit)
+	if more {
+		rows = rows[:p.Limit]
+	}
+
+	if p.Backward {
+		slices.Reverse(rows)
+		return page[T]{Items: rows, HasNext: true, HasPrev: more}, nil
+	}
+
+	return page[T]{Items: rows, HasNext: more, HasPrev: p.Cursor != nil}, nil
+}
+
+// setPageLinks writes an RFC 8288 Link header pointing at the neighbouring
+// pages, reusing every other query parameter from the current request.
+func setPageLinks[T any](w http.ResponseWriter, r *http.Request, pg page[T], keyOf func(T) cursor) {
+	if len(pg.Items) == 0 {
+		return
+	}
+
+	link := func(param string, c cursor, rel string) string {
+		query := r.URL.Query()
+		query.Del("before")
+		query.Del("after")
+		query.Set(param, c.encode())
+		u := url.URL{Path: r.URL.Path, RawQuery: query.Encode()}
+		return fmt.Sprintf(`<%s>; rel="%s"`, u.String(), rel)
+	}
+
+	var links []string
+	if pg.HasNext {
+		links = append(links, link("after", keyOf(pg.Items[len(pg.Items)-1]), "next"))
+	}
+	if pg.HasPrev {
+		links = append(links, link("before", keyOf(pg.Items[0]), "prev"))
+	}
+
+	if len(links) > 0 {
+		w.Header().Set("Link", strings.Join(links, ", "))
+	}
+}
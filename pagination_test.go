@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"net/url"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestCursorRoundTrip(t *testing.T) {
+	c := cursor{
+		CreatedAt: time.Date(2024, 6, 1, 12, 30, 0, 123456000, time.UTC),
+		ID:        uuid.MustParse("0b5d8c1e-3f7a-4c2b-9d6e-1a2b3c4d5e6f"),
+	}
+	got, err := decodeCursor(c.encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.CreatedAt.Equal(c.CreatedAt) || got.ID != c.ID {
+		t.Errorf("decodeCursor(encode()) = %+v, want %+v", got, c)
+	}
+}
+
+func TestDecodeCursorRejectsMalformed(t *testing.T) {
+	valid := cursor{CreatedAt: time.Now(), ID: uuid.New()}.encode()
+	enc := func(s string) string { return base64.RawURLEncoding.EncodeToString([]byte(s)) }
+
+	tests := []struct {
+		name   string
+		cursor string
+	}{
+		{"empty", ""},
+		{"not base64", "not a cursor!"},
+		{"padded base64", valid + "="},
+		{"standard base64", base64.StdEncoding.EncodeToString([]byte("1717245000000000:" + uuid.NewString()))},
+		{"truncated", valid[:len(valid)-4]},
+		{"tampered", "A" + valid[1:]},
+		{"no separator", enc("1717245000000000" + uuid.NewString())},
+		{"time not a number", enc("yesterday:" + uuid.NewString())},
+		{"bad id", enc("1717245000000000:not-a-uuid")},
+		{"missing id", enc("1717245000000000:")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := decodeCursor(tt.cursor); err == nil {
+				t.Errorf("decodeCursor(%q) accepted a malformed cursor", tt.cursor)
+			}
+			if _, err := parsePageParams(url.Values{"after": {tt.cursor}}); err == nil && tt.cursor != "" {
+				t.Errorf("parsePageParams accepted after=%q", tt.cursor)
+			}
+		})
+	}
+}
+
+func TestParsePageParams(t *testing.T) {
+	c := cursor{CreatedAt: time.UnixMicro(1717245000000000).UTC(), ID: uuid.New()}
+
+	tests := []struct {
+		name     string
+		query    url.Values
+		limit    int32
+		backward bool
+		cursor   bool
+		wantErr  bool
+	}{
+		{"defaults", url.Values{}, defaultPageLimit, false, false, false},
+		{"limit", url.Values{"limit": {"20"}}, 20, false, false, false},
+		{"limit capped", url.Values{"limit": {"1000"}}, maxPageLimit, false, false, false},
+		{"zero limit", url.Values{"limit": {"0"}}, 0, false, false, true},
+		{"bad limit", url.Values{"limit": {"ten"}}, 0, false, false, true},
+		{"after", url.Values{"after": {c.encode()}}, defaultPageLimit, false, true, false},
+		{"before", url.Values{"before": {c.encode()}}, defaultPageLimit, true, true, false},
+		{"both", url.Values{"after": {c.encode()}, "before": {c.encode()}}, 0, false, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := parsePageParams(tt.query)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("parsePageParams accepted the query")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if p.Limit != tt.limit || p.Backward != tt.backward || (p.Cursor != nil) != tt.cursor {
+				t.Errorf("parsePageParams = %+v", p)
+			}
+			if tt.cursor && (!p.Cursor.CreatedAt.Equal(c.CreatedAt) || p.Cursor.ID != c.ID) {
+				t.Errorf("cursor = %+v, want %+v", *p.Cursor, c)
+			}
+		})
+	}
+}
+
+type testRow struct {
+	CreatedAt time.Time
+	ID        uuid.UUID
+}
+
+func compareRows(a, b testRow) int {
+	if c := a.CreatedAt.Compare(b.CreatedAt); c != 0 {
+		return c
+	}
+	return bytes.Compare(a.ID[:], b.ID[:])
+}
+
+// memoryFetcher pages through rows the way ListChirpsAfter and
+// ListChirpsBefore do, comparing (created_at, id) as a row value.
+func memoryFetcher(rows []testRow) pageFetcher[testRow] {
+	sorted := slices.Clone(rows)
+	slices.SortFunc(sorted, compareRows)
+
+	return func(p pageParams, limit int32, ascending bool) ([]testRow, error) {
+		var out []testRow
+		walk := slices.Clone(sorted)
+		if !ascending {
+			slices.Reverse(walk)
+		}
+		for _, row := range walk {
+			if p.Cursor != nil {
+				c := compareRows(row, testRow{p.Cursor.CreatedAt, p.Cursor.ID})
+				if (ascending && c <= 0) || (!ascending && c >= 0) {
+					continue
+				}
+			}
+			out = append(out, row)
+			if len(out) == int(limit) {
+				break
+			}
+		}
+		return out, nil
+	}
+}
+
+func rowCursor(r testRow) cursor {
+	return cursor{CreatedAt: r.CreatedAt, ID: r.ID}
+}
+
+// TestFetchPageTiedTimestamps walks every page forward and back through rows
+// that share created_at values, going through encoded cursors as a client
+// would, and checks that no row is skipped or repeated.
+func TestFetchPageTiedTimestamps(t *testing.T) {
+	base := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	var rows []testRow
+	for i := range 23 {
+		// Groups of five rows share a timestamp, so pages of three
+		// split them.
+		rows = append(rows, testRow{CreatedAt: base.Add(time.Duration(i/5) * time.Second), ID: uuid.New()})
+	}
+	fetch := memoryFetcher(rows)
+
+	for _, desc := range []bool{false, true} {
+		want := slices.Clone(rows)
+		slices.SortFunc(want, compareRows)
+		if desc {
+			slices.Reverse(want)
+		}
+
+		var forward []testRow
+		p := pageParams{Limit: 3}
+		for {
+			pg, err := fetchPage(p, desc, fetch)
+			if err != nil {
+				t.Fatal(err)
+			}
+			forward = append(forward, pg.Items...)
+			if !pg.HasNext {
+				break
+			}
+			c, err := decodeCursor(rowCursor(pg.Items[len(pg.Items)-1]).encode())
+			if err != nil {
+				t.Fatal(err)
+			}
+			p = pageParams{Limit: 3, Cursor: &c}
+		}
+		if !slices.Equal(forward, want) {
+			t.Fatalf("desc=%v: paging forward returned %d rows out of order or with gaps, want %d", desc, len(forward), len(want))
+		}
+
+		var backward []testRow
+		last := rowCursor(want[len(want)-1])
+		p = pageParams{Limit: 3, Cursor: &last, Backward: true}
+		for {
+			pg, err := fetchPage(p, desc, fetch)
+			if err != nil {
+				t.Fatal(err)
+			}
+			backward = append(slices.Clone(pg.Items), backward...)
+			if !pg.HasPrev {
+				break
+			}
+			c, err := decodeCursor(rowCursor(pg.Items[0]).encode())
+			if err != nil {
+				t.Fatal(err)
+			}
+			p = pageParams{Limit: 3, Cursor: &c, Backward: true}
+		}
+		if !slices.Equal(backward, want[:len(want)-1]) {
+			t.Fatalf("desc=%v: paging backward returned %d rows out of order or with gaps, want %d", desc, len(backward), len(want)-1)
+		}
+	}
+}
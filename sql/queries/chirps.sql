@@ -1,27 +1,79 @@
 -- name: CreateChirp :one
-INSERT INTO chirps (id, created_at, updated_at, body, user_id)
+INSERT INTO chirps (id, created_at, updated_at, body, user_id, parent_id, root_id, media_urls)
 VALUES (
     gen_random_uuid(),
     now(),
     now(),
     $1,
-    $2
+    $2,
+    $3,
+    $4,
+    $5
 )
 RETURNING *;
 
--- name: GetChirps :many
-SELECT * FROM chirps 
-ORDER BY created_at;
+-- name: UpdateChirpBody :one
+UPDATE chirps
+SET body = $2, updated_at = now()
+WHERE id = $1
+AND deleted_at IS NULL
+RETURNING *;
+
+-- name: LockUserChirps :exec
+-- Held until the end of the transaction, so that a user's chirps are
+-- counted against their quota one post at a time.
+SELECT id FROM users
+WHERE id = $1
+FOR UPDATE;
+
+-- name: CountChirpsSince :one
+-- Deleted chirps count too, so deleting one does not hand back quota.
+SELECT count(*) FROM chirps
+WHERE user_id = $1
+AND created_at > $2;
 
 -- name: GetChirp :one
 SELECT * FROM chirps
 WHERE id = $1;
 
--- name: DeleteChirp :exec
-DELETE FROM chirps
-WHERE id = $1;
+-- name: SoftDeleteChirp :one
+UPDATE chirps
+SET body = '', media_urls = '{}', deleted_at = now(), updated_at = now()
+WHERE id = $1
+RETURNING *;
 
--- name: GetChirpFromAuthorId :many
-SELECT * FROM chirps 
-WHERE user_id = $1
-ORDER BY created_at;
\ No newline at end of file
+-- name: GetThread :many
+SELECT * FROM chirps
+WHERE id = sqlc.arg('root_id') OR root_id = sqlc.arg('root_id')
+ORDER BY created_at, id;
+
+-- name: ListChirpsAfter :many
+SELECT * FROM chirps
+WHERE deleted_at IS NULL
+AND (sqlc.narg('author_id')::uuid IS NULL OR user_id = sqlc.narg('author_id'))
+AND (
+    sqlc.narg('cursor_created_at')::timestamp IS NULL
+    OR (created_at, id) > (sqlc.narg('cursor_created_at')::timestamp, sqlc.narg('cursor_id')::uuid)
+)
+ORDER BY created_at, id
+LIMIT sqlc.arg('limit');
+
+-- name: ListChirpsBefore :many
+SELECT * FROM chirps
+WHERE deleted_at IS NULL
+AND (sqlc.narg('author_id')::uuid IS NULL OR user_id = sqlc.narg('author_id'))
+AND (
+    sqlc.narg('cursor_created_at')::timestamp IS NULL
+    OR (created_at, id) < (sqlc.narg('cursor_created_at')::timestamp, sqlc.narg('cursor_id')::uuid)
+)
+ORDER BY created_at DESC, id DESC
+LIMIT sqlc.arg('limit');
+
+-- name: ListChirpChangesSince :many
+-- Chirps created or deleted at or after since, for replaying the stream,
+-- in order of their first change since then.
+SELECT * FROM chirps
+WHERE (created_at >= sqlc.arg('since') OR deleted_at >= sqlc.arg('since'))
+AND (sqlc.narg('author_ids')::uuid[] IS NULL OR user_id = ANY(sqlc.narg('author_ids')::uuid[]))
+ORDER BY CASE WHEN created_at >= sqlc.arg('since') THEN created_at ELSE deleted_at END, id
+LIMIT sqlc.arg('limit');
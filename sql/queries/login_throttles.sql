@@ -0,0 +1,23 @@
+-- name: GetLoginThrottle :one
+SELECT * FROM login_throttles
+WHERE throttle_key = $1;
+
+-- name: RecordLoginFailure :one
+INSERT INTO login_throttles (throttle_key, failed_count, last_failed_at)
+VALUES ($1, 1, now())
+ON CONFLICT (throttle_key) DO UPDATE
+SET failed_count = CASE
+        WHEN login_throttles.last_failed_at < sqlc.arg('reset_before')::timestamp THEN 1
+        ELSE login_throttles.failed_count + 1
+    END,
+    last_failed_at = now()
+RETURNING failed_count;
+
+-- name: LockLogin :exec
+UPDATE login_throttles
+SET locked_until = $2
+WHERE throttle_key = $1;
+
+-- name: ClearLoginThrottle :exec
+DELETE FROM login_throttles
+WHERE throttle_key = $1;
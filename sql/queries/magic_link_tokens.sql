@@ -0,0 +1,23 @@
+-- name: CreateMagicLinkToken :one
+INSERT INTO magic_link_tokens (token_hash, created_at, user_id, expires_at)
+VALUES (
+    $1,
+    now(),
+    $2,
+    $3
+)
+RETURNING *;
+
+-- name: ConsumeMagicLinkToken :one
+UPDATE magic_link_tokens
+SET used_at = now()
+WHERE token_hash = $1
+AND used_at IS NULL
+AND expires_at > now()
+RETURNING *;
+
+-- name: InvalidateMagicLinkTokens :exec
+UPDATE magic_link_tokens
+SET used_at = now()
+WHERE user_id = $1
+AND used_at IS NULL;
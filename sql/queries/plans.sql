@@ -0,0 +1,28 @@
+-- name: GetPlan :one
+SELECT * FROM plans
+WHERE name = $1;
+
+-- name: ListPlans :many
+SELECT * FROM plans
+ORDER BY name;
+
+-- name: UpsertPlan :one
+INSERT INTO plans (name, created_at, updated_at, max_chirp_length, can_edit_chirps, daily_chirp_quota, max_media_attachments, requests_per_minute)
+VALUES (
+    $1,
+    now(),
+    now(),
+    $2,
+    $3,
+    $4,
+    $5,
+    $6
+)
+ON CONFLICT (name) DO UPDATE
+SET max_chirp_length = EXCLUDED.max_chirp_length,
+    can_edit_chirps = EXCLUDED.can_edit_chirps,
+    daily_chirp_quota = EXCLUDED.daily_chirp_quota,
+    max_media_attachments = EXCLUDED.max_media_attachments,
+    requests_per_minute = EXCLUDED.requests_per_minute,
+    updated_at = now()
+RETURNING *;
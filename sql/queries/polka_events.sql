@@ -0,0 +1,10 @@
+-- name: RecordPolkaEvent :one
+INSERT INTO polka_events (event_id, received_at, event, user_id)
+VALUES (
+    $1,
+    now(),
+    $2,
+    $3
+)
+ON CONFLICT (event_id) DO NOTHING
+RETURNING event_id;
@@ -0,0 +1,73 @@
+-- name: UpsertPendingTOTP :one
+INSERT INTO user_totp (user_id, secret, enabled, last_used_step, failed_attempts, created_at, updated_at)
+VALUES (
+    $1,
+    $2,
+    FALSE,
+    0,
+    0,
+    now(),
+    now()
+)
+ON CONFLICT (user_id) DO UPDATE
+SET secret = EXCLUDED.secret,
+    enabled = FALSE,
+    last_used_step = 0,
+    failed_attempts = 0,
+    locked_until = NULL,
+    updated_at = now()
+RETURNING *;
+
+-- name: GetUserTOTP :one
+SELECT * FROM user_totp
+WHERE user_id = $1;
+
+-- name: EnableUserTOTP :exec
+UPDATE user_totp
+SET enabled = TRUE, updated_at = now()
+WHERE user_id = $1;
+
+-- name: DeleteUserTOTP :exec
+DELETE FROM user_totp
+WHERE user_id = $1;
+
+-- name: RecordTOTPUse :execrows
+UPDATE user_totp
+SET last_used_step = $2, failed_attempts = 0, locked_until = NULL, updated_at = now()
+WHERE user_id = $1
+AND last_used_step < $2;
+
+-- name: RecordTOTPFailure :one
+UPDATE user_totp
+SET failed_attempts = failed_attempts + 1,
+    locked_until = CASE
+        WHEN failed_attempts + 1 >= sqlc.arg('max_failures')::int THEN sqlc.arg('locked_until')::timestamp
+        ELSE locked_until
+    END,
+    updated_at = now()
+WHERE user_id = sqlc.arg('user_id')
+RETURNING failed_attempts;
+
+-- name: CreateRecoveryCode :exec
+INSERT INTO recovery_codes (user_id, code_hash, created_at)
+VALUES (
+    $1,
+    $2,
+    now()
+);
+
+-- name: UseRecoveryCode :execrows
+UPDATE recovery_codes
+SET used_at = now()
+WHERE user_id = $1
+AND code_hash = $2
+AND used_at IS NULL;
+
+-- name: DeleteRecoveryCodes :exec
+DELETE FROM recovery_codes
+WHERE user_id = $1;
+
+-- name: ResetTOTPFailures :exec
+UPDATE user_totp
+SET failed_attempts = 0, locked_until = NULL, updated_at = now()
+WHERE user_id = $1;
@@ -1,11 +1,12 @@
 -- name: CreateUser :one
-INSERT INTO users (id, created_at, updated_at, email, hashed_password)
+INSERT INTO users (id, created_at, updated_at, email, hashed_password, handle)
 VALUES (
     gen_random_uuid(),
     now(),
     now(),
     $1,
-    $2
+    $2,
+    $3
 )
 RETURNING *;
 
@@ -24,17 +25,48 @@ WHERE id = $1;
 SELECT users.* FROM users
 JOIN refresh_tokens
 ON users.id = refresh_tokens.user_id
-WHERE refresh_tokens.token = $1 
+WHERE refresh_tokens.token_hash = $1 
 AND expires_at > NOW()
 AND refresh_tokens.revoked_at IS NULL;
 
 -- name: UpdateUserCredentials :one
 UPDATE users
-SET email = $2, hashed_password = $3
+SET email = $2, hashed_password = $3, verified = (verified AND email = $2)
 WHERE id = $1
 RETURNING *;
 
--- name: UpgradeUser :exec
+-- name: GetUserFromHandle :one
+SELECT * FROM users
+WHERE lower(handle) = lower(sqlc.arg('handle'));
+
+-- name: UpdateUserProfile :one
+UPDATE users
+SET handle = COALESCE(sqlc.narg('handle'), handle),
+    display_name = COALESCE(sqlc.narg('display_name'), display_name),
+    bio = COALESCE(sqlc.narg('bio'), bio),
+    avatar_url = COALESCE(sqlc.narg('avatar_url'), avatar_url),
+    updated_at = now()
+WHERE id = sqlc.arg('id')
+RETURNING *;
+
+-- name: GetAuthors :many
+SELECT id, handle, display_name, avatar_url FROM users
+WHERE id = ANY(sqlc.arg('ids')::uuid[]);
+
+-- name: UpdateUserRole :one
 UPDATE users
-SET is_chirpy_red = TRUE
-WHERE id = $1;
\ No newline at end of file
+SET role = $2, updated_at = now()
+WHERE id = $1
+RETURNING *;
+
+-- name: VerifyUserEmail :one
+UPDATE users
+SET verified = TRUE, updated_at = now()
+WHERE id = $1
+AND email = $2
+RETURNING *;
+
+-- name: UpdateUserPassword :exec
+UPDATE users
+SET hashed_password = $2, updated_at = now()
+WHERE id = $1;
@@ -0,0 +1,92 @@
+-- name: CreateWebhookEndpoint :one
+INSERT INTO webhook_endpoints (id, created_at, updated_at, owner_id, url, secret, events)
+VALUES (
+    gen_random_uuid(),
+    now(),
+    now(),
+    $1,
+    $2,
+    $3,
+    $4
+)
+RETURNING *;
+
+-- name: GetWebhookEndpoint :one
+SELECT * FROM webhook_endpoints
+WHERE id = $1
+AND owner_id = $2;
+
+-- name: GetWebhookEndpointByID :one
+SELECT * FROM webhook_endpoints
+WHERE id = $1;
+
+-- name: ListWebhookEndpoints :many
+SELECT * FROM webhook_endpoints
+WHERE owner_id = $1
+ORDER BY created_at DESC;
+
+-- name: CountWebhookEndpoints :one
+SELECT count(*) FROM webhook_endpoints
+WHERE owner_id = $1;
+
+-- name: DeleteWebhookEndpoint :execrows
+DELETE FROM webhook_endpoints
+WHERE id = $1
+AND owner_id = $2;
+
+-- name: EnqueueWebhookDeliveries :execrows
+-- Queues the event for every endpoint subscribed to it. Without an owner
+-- the event is public and goes to every subscriber.
+INSERT INTO webhook_deliveries (id, created_at, updated_at, endpoint_id, event_id, event, payload, status, attempts, next_attempt_at)
+SELECT gen_random_uuid(), now(), now(), id, sqlc.arg('event_id'), sqlc.arg('event'), sqlc.arg('payload'), 'pending', 0, now()
+FROM webhook_endpoints
+WHERE sqlc.arg('event')::text = ANY(events)
+AND (sqlc.narg('owner_id')::uuid IS NULL OR owner_id = sqlc.narg('owner_id'));
+
+-- name: ClaimWebhookDeliveries :many
+-- Pushes the next attempt of due deliveries back to lease_until, so no
+-- other worker picks them up while this one sends them.
+UPDATE webhook_deliveries
+SET next_attempt_at = sqlc.arg('lease_until')
+WHERE id IN (
+    SELECT id FROM webhook_deliveries
+    WHERE next_attempt_at <= now()
+    ORDER BY next_attempt_at
+    LIMIT sqlc.arg('limit')
+    FOR UPDATE SKIP LOCKED
+)
+RETURNING *;
+
+-- name: RecordWebhookAttempt :exec
+UPDATE webhook_deliveries
+SET status = $2,
+    attempts = attempts + 1,
+    next_attempt_at = $3,
+    last_attempt_at = now(),
+    response_status = $4,
+    last_error = $5,
+    delivered_at = CASE WHEN $2 = 'delivered' THEN now() ELSE delivered_at END,
+    updated_at = now()
+WHERE id = $1;
+
+-- name: ListWebhookDeliveries :many
+SELECT * FROM webhook_deliveries
+WHERE endpoint_id = $1
+ORDER BY created_at DESC
+LIMIT $2;
+
+-- name: GetWebhookDelivery :one
+SELECT * FROM webhook_deliveries
+WHERE id = $1
+AND endpoint_id = $2;
+
+-- name: RedeliverWebhookDelivery :one
+-- Starts a delivery over with a full set of retries. A delivery that is
+-- queued, or leased by a worker sending it, is left alone.
+UPDATE webhook_deliveries
+SET status = 'pending', attempts = 0, next_attempt_at = now(), last_error = NULL, updated_at = now()
+WHERE id = $1
+AND endpoint_id = $2
+AND status IN ('dead', 'failed', 'delivered')
+AND (next_attempt_at IS NULL OR next_attempt_at <= now())
+RETURNING *;
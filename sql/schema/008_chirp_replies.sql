@@ -0,0 +1,15 @@
+-- +goose Up
+ALTER TABLE chirps
+ADD parent_id UUID REFERENCES chirps(id) ON DELETE SET NULL,
+ADD root_id UUID REFERENCES chirps(id) ON DELETE SET NULL,
+ADD deleted_at TIMESTAMP;
+
+CREATE INDEX chirps_root_id_idx ON chirps (root_id, created_at, id);
+
+-- +goose Down
+DROP INDEX chirps_root_id_idx;
+
+ALTER TABLE chirps
+DROP COLUMN deleted_at,
+DROP COLUMN root_id,
+DROP COLUMN parent_id;
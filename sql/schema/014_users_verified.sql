@@ -0,0 +1,11 @@
+-- +goose Up
+ALTER TABLE users
+ADD verified BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- Accounts created before verification existed keep posting.
+UPDATE users
+SET verified = TRUE;
+
+-- +goose Down
+ALTER TABLE users
+DROP COLUMN verified;
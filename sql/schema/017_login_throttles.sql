@@ -0,0 +1,13 @@
+-- +goose Up
+-- One row per login throttle key, such as "email:alice@example.com" or
+-- "ip:203.0.113.7". Keyed by the submitted email rather than the user, so
+-- unknown addresses are throttled exactly like registered ones.
+CREATE TABLE login_throttles (
+    throttle_key TEXT PRIMARY KEY,
+    failed_count INTEGER NOT NULL DEFAULT 0,
+    last_failed_at TIMESTAMP NOT NULL,
+    locked_until TIMESTAMP
+);
+
+-- +goose Down
+DROP TABLE login_throttles;
@@ -0,0 +1,47 @@
+-- +goose Up
+CREATE TABLE oauth_clients (
+    id UUID PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL,
+    owner_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    name TEXT NOT NULL,
+    -- NULL for public clients, such as mobile and single-page apps, which
+    -- cannot keep a secret and rely on PKCE alone.
+    secret_hash TEXT,
+    -- Space-delimited; redirect URIs may not contain spaces.
+    redirect_uris TEXT NOT NULL,
+    scope TEXT NOT NULL
+);
+
+CREATE INDEX oauth_clients_owner_id_idx ON oauth_clients (owner_id);
+
+CREATE TABLE oauth_authorization_codes (
+    code_hash TEXT PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL,
+    client_id UUID NOT NULL REFERENCES oauth_clients(id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    redirect_uri TEXT NOT NULL,
+    scope TEXT NOT NULL,
+    code_challenge TEXT NOT NULL,
+    -- The refresh token family the code is exchanged into, so that a
+    -- replayed code can revoke the tokens the first exchange issued.
+    family_id UUID NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    used_at TIMESTAMP
+);
+
+-- Refresh tokens now record which client they were issued to, if any, and
+-- the scopes their access tokens carry. Existing tokens all came from a
+-- password login, which grants every scope.
+ALTER TABLE refresh_tokens
+ADD client_id UUID REFERENCES oauth_clients(id) ON DELETE CASCADE,
+ADD scope TEXT NOT NULL DEFAULT '';
+
+UPDATE refresh_tokens SET scope = 'chirps:read chirps:write profile:write account';
+
+-- +goose Down
+ALTER TABLE refresh_tokens
+DROP COLUMN scope,
+DROP COLUMN client_id;
+
+DROP TABLE oauth_authorization_codes;
+DROP TABLE oauth_clients;
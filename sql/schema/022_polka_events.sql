@@ -0,0 +1,12 @@
+-- +goose Up
+-- Every Polka webhook delivery that was processed, by Polka's event ID.
+-- Retries of a delivered event are acknowledged without running again.
+CREATE TABLE polka_events (
+    event_id TEXT PRIMARY KEY,
+    received_at TIMESTAMP NOT NULL,
+    event TEXT NOT NULL,
+    user_id UUID
+);
+
+-- +goose Down
+DROP TABLE polka_events;
@@ -0,0 +1,56 @@
+-- +goose Up
+-- A user's Chirpy Red membership. Status moves between active, past_due
+-- (a payment failed), canceled (won't renew) and expired. All but expired
+-- keep the membership until current_period_end; a NULL end never lapses.
+CREATE TABLE subscriptions (
+    user_id UUID PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL,
+    plan TEXT NOT NULL,
+    status TEXT NOT NULL CHECK (status IN ('active', 'past_due', 'canceled', 'expired')),
+    current_period_start TIMESTAMP NOT NULL,
+    current_period_end TIMESTAMP
+);
+
+CREATE INDEX subscriptions_lapsing_idx ON subscriptions (current_period_end)
+WHERE status <> 'expired';
+
+-- Every change to a subscription, newest last.
+CREATE TABLE subscription_events (
+    id UUID PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    event TEXT NOT NULL,
+    plan TEXT NOT NULL,
+    status TEXT NOT NULL,
+    current_period_end TIMESTAMP,
+    polka_event_id TEXT
+);
+
+CREATE INDEX subscription_events_user_id_idx ON subscription_events (user_id, created_at);
+
+-- Members upgraded before subscriptions existed keep Chirpy Red with no
+-- end date until Polka tells us otherwise.
+INSERT INTO subscriptions (user_id, created_at, updated_at, plan, status, current_period_start, current_period_end)
+SELECT id, now(), now(), 'chirpy_red', 'active', updated_at, NULL
+FROM users
+WHERE is_chirpy_red;
+
+INSERT INTO subscription_events (id, created_at, user_id, event, plan, status, current_period_end, polka_event_id)
+SELECT gen_random_uuid(), now(), user_id, 'migrated', plan, status, NULL, NULL
+FROM subscriptions;
+
+ALTER TABLE users DROP COLUMN is_chirpy_red;
+
+-- +goose Down
+ALTER TABLE users ADD is_chirpy_red BOOLEAN DEFAULT FALSE;
+
+UPDATE users
+SET is_chirpy_red = TRUE
+FROM subscriptions
+WHERE subscriptions.user_id = users.id
+AND subscriptions.status <> 'expired'
+AND (subscriptions.current_period_end IS NULL OR subscriptions.current_period_end > now());
+
+DROP TABLE subscription_events;
+DROP TABLE subscriptions;
@@ -0,0 +1,24 @@
+-- +goose Up
+-- What each plan entitles its members to. Users without an active
+-- subscription get the free plan. A quota or rate of 0 means unlimited.
+CREATE TABLE plans (
+    name TEXT PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL,
+    max_chirp_length INTEGER NOT NULL CHECK (max_chirp_length > 0),
+    can_edit_chirps BOOLEAN NOT NULL,
+    daily_chirp_quota INTEGER NOT NULL CHECK (daily_chirp_quota >= 0),
+    max_media_attachments INTEGER NOT NULL CHECK (max_media_attachments >= 0),
+    requests_per_minute INTEGER NOT NULL CHECK (requests_per_minute >= 0)
+);
+
+INSERT INTO plans (name, created_at, updated_at, max_chirp_length, can_edit_chirps, daily_chirp_quota, max_media_attachments, requests_per_minute)
+VALUES
+    ('free', now(), now(), 140, false, 100, 0, 60),
+    ('chirpy_red', now(), now(), 280, true, 0, 4, 600);
+
+ALTER TABLE chirps ADD COLUMN media_urls TEXT[] NOT NULL DEFAULT '{}';
+
+-- +goose Down
+ALTER TABLE chirps DROP COLUMN media_urls;
+DROP TABLE plans;
@@ -0,0 +1,7 @@
+-- +goose Up
+-- For replaying deletions to stream clients that reconnect.
+CREATE INDEX chirps_deleted_at_idx ON chirps (deleted_at)
+WHERE deleted_at IS NOT NULL;
+
+-- +goose Down
+DROP INDEX chirps_deleted_at_idx;
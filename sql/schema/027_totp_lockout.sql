@@ -0,0 +1,7 @@
+-- +goose Up
+-- Wrong two-factor codes lock the second step for a while, however many
+-- times the password step is passed in the meantime.
+ALTER TABLE user_totp ADD COLUMN locked_until TIMESTAMP;
+
+-- +goose Down
+ALTER TABLE user_totp DROP COLUMN locked_until;
@@ -0,0 +1,8 @@
+-- +goose Up
+-- A subscription must be to a plan that exists, so a plan name Polka gets
+-- wrong can't quietly fall back to the free plan's limits.
+ALTER TABLE subscriptions
+ADD CONSTRAINT subscriptions_plan_fkey FOREIGN KEY (plan) REFERENCES plans(name);
+
+-- +goose Down
+ALTER TABLE subscriptions DROP CONSTRAINT subscriptions_plan_fkey;
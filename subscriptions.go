@@ -0,0 +1,325 @@
+package main
+
+import (
+	"chirpy/internal/auth"
+	"chirpy/internal/database"
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	subscriptionActive   = "active"
+	subscriptionPastDue  = "past_due"
+	subscriptionCanceled = "canceled"
+	subscriptionExpired  = "expired"
+
+	defaultPlan = "chirpy_red"
+	// subscriptionPeriod is the billing period assumed when Polka does not
+	// say when a period ends.
+	subscriptionPeriod = time.Hour * 24 * 30
+)
+
+var (
+	errSubscriptionNotFound = errors.New("User has no subscription")
+	errUnknownPlan          = errors.New("Unknown plan")
+)
+
+// polkaSubscriptionEvents are the Polka events applySubscriptionEvent acts
+// on. Polka may send others, which are acknowledged and ignored.
+var polkaSubscriptionEvents = map[string]bool{
+	"user.upgraded":               true,
+	"user.downgraded":             true,
+	"subscription.renewed":        true,
+	"subscription.payment_failed": true,
+	"subscription.canceled":       true,
+}
+
+// subscriptionIsActive reports whether s grants membership at now. Past due
+// and canceled subscriptions keep it until the paid period runs out.
+func subscriptionIsActive(s database.Subscription, now time.Time) bool {
+	if s.Status == subscriptionExpired {
+		return false
+	}
+	return !s.CurrentPeriodEnd.Valid || now.Before(s.CurrentPeriodEnd.Time)
+}
+
+// activeSubscription returns the user's subscription if it currently grants
+// membership.
+func (apiCfg *apiConfig) activeSubscription(ctx context.Context, userID uuid.UUID) (database.Subscription, bool, error) {
+	subscription, err := apiCfg.DB.GetSubscription(ctx, userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return database.Subscription{}, false, nil
+	}
+	if err != nil {
+		return database.Subscription{}, false, err
+	}
+	return subscription, subscriptionIsActive(subscription, time.Now()), nil
+}
+
+// userResponse is the account owner's view of dbUser, with membership
+// derived from their subscription.
+func (apiCfg *apiConfig) userResponse(ctx context.Context, dbUser database.User) User {
+	user := databaseUserToUser(dbUser)
+	_, active, err := apiCfg.activeSubscription(ctx, dbUser.ID)
+	if err != nil {
+		log.Printf("Error loading subscription for user %s: %s", dbUser.ID, err)
+	}
+	user.IsChirpyRed = active
+	return user
+}
+
+// polkaEventData is the data of a Polka subscription event. Everything but
+// the user is optional.
+type polkaEventData struct {
+	UserID      uuid.UUID  `json:"user_id"`
+	Plan        string     `json:"plan"`
+	PeriodStart *time.Time `json:"period_start"`
+	PeriodEnd   *time.Time `json:"period_end"`
+}
+
+// applySubscriptionEvent changes the user's subscription as a Polka event
+// says and records the change in its history. Events it doesn't know are
+// ignored. Events about a subscription the user doesn't have, or that has
+// already expired, return errSubscriptionNotFound, and events naming a plan
+// that isn't defined return errUnknownPlan. Upgrades are passed on to the
+// user's webhooks.
+func applySubscriptionEvent(ctx context.Context, qtx *database.Queries, event string, data polkaEventData, polkaEventID string) error {
+	now := time.Now()
+	var subscription database.Subscription
+	var err error
+
+	// Only upgrades and renewals store the plan Polka sends.
+	if data.Plan != "" && (event == "user.upgraded" || event == "subscription.renewed") {
+		if _, err := qtx.GetPlan(ctx, data.Plan); errors.Is(err, sql.ErrNoRows) {
+			return errUnknownPlan
+		} else if err != nil {
+			return err
+		}
+	}
+
+	switch event {
+	case "user.upgraded":
+		start := now
+		if data.PeriodStart != nil {
+			start = *data.PeriodStart
+		}
+		subscription, err = qtx.UpsertSubscription(ctx, database.UpsertSubscriptionParams{
+			UserID:             data.UserID,
+			Plan:               planOrDefault(data.Plan, defaultPlan),
+			Status:             subscriptionActive,
+			CurrentPeriodStart: start,
+			CurrentPeriodEnd:   periodEnd(data, start),
+		})
+
+	case "subscription.renewed":
+		current, getErr := qtx.GetSubscription(ctx, data.UserID)
+		if getErr != nil {
+			err = getErr
+			break
+		}
+		// Only user.upgraded brings an expired subscription back.
+		if current.Status == subscriptionExpired {
+			err = errSubscriptionNotFound
+			break
+		}
+		// A renewal paid before the period ran out extends it; a late one
+		// starts a new period now.
+		start := now
+		if current.CurrentPeriodEnd.Valid && current.CurrentPeriodEnd.Time.After(now) {
+			start = current.CurrentPeriodEnd.Time
+		}
+		if data.PeriodStart != nil {
+			start = *data.PeriodStart
+		}
+		subscription, err = qtx.UpsertSubscription(ctx, database.UpsertSubscriptionParams{
+			UserID:             data.UserID,
+			Plan:               planOrDefault(data.Plan, current.Plan),
+			Status:             subscriptionActive,
+			CurrentPeriodStart: start,
+			CurrentPeriodEnd:   periodEnd(data, start),
+		})
+
+	case "subscription.payment_failed":
+		subscription, err = qtx.UpdateSubscriptionStatus(ctx, database.UpdateSubscriptionStatusParams{
+			UserID: data.UserID,
+			Status: subscriptionPastDue,
+		})
+
+	case "subscription.canceled":
+		subscription, err = qtx.UpdateSubscriptionStatus(ctx, database.UpdateSubscriptionStatusParams{
+			UserID: data.UserID,
+			Status: subscriptionCanceled,
+		})
+
+	case "user.downgraded":
+		subscription, err = qtx.EndSubscription(ctx, data.UserID)
+
+	default:
+		return nil
+	}
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return errSubscriptionNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := recordSubscriptionEvent(ctx, qtx, subscription, event, polkaEventID); err != nil {
+		return err
+	}
+
+	if event == "user.upgraded" {
+		_, err = queueWebhookEvent(ctx, qtx, webhookUserUpgraded, uuid.NullUUID{UUID: subscription.UserID, Valid: true}, map[string]any{
+			"user_id": subscription.UserID,
+			"plan":    subscription.Plan,
+		})
+	}
+	return err
+}
+
+func planOrDefault(plan, fallback string) string {
+	if plan == "" {
+		return fallback
+	}
+	return plan
+}
+
+func periodEnd(data polkaEventData, start time.Time) sql.NullTime {
+	if data.PeriodEnd != nil {
+		return sql.NullTime{Time: *data.PeriodEnd, Valid: true}
+	}
+	return sql.NullTime{Time: start.Add(subscriptionPeriod), Valid: true}
+}
+
+func recordSubscriptionEvent(ctx context.Context, qtx *database.Queries, subscription database.Subscription, event, polkaEventID string) error {
+	return qtx.CreateSubscriptionEvent(ctx, database.CreateSubscriptionEventParams{
+		UserID:           subscription.UserID,
+		Event:            event,
+		Plan:             subscription.Plan,
+		Status:           subscription.Status,
+		CurrentPeriodEnd: subscription.CurrentPeriodEnd,
+		PolkaEventID:     sql.NullString{String: polkaEventID, Valid: polkaEventID != ""},
+	})
+}
+
+// runSubscriptionExpiry expires lapsed memberships every interval until ctx
+// is done. Each subscription is expired by exactly one UPDATE, so it is
+// safe to run on every instance.
+func (apiCfg *apiConfig) runSubscriptionExpiry(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := apiCfg.expireLapsedSubscriptions(ctx); err != nil {
+			log.Printf("Error expiring subscriptions: %s", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (apiCfg *apiConfig) expireLapsedSubscriptions(ctx context.Context) error {
+	tx, err := apiCfg.DBConn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	qtx := apiCfg.DB.WithTx(tx)
+
+	expired, err := qtx.ExpireLapsedSubscriptions(ctx)
+	if err != nil {
+		return err
+	}
+	for _, subscription := range expired {
+		if err := recordSubscriptionEvent(ctx, qtx, subscription, "expired", ""); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	if len(expired) > 0 {
+		log.Printf("Expired %d lapsed subscriptions", len(expired))
+	}
+	return nil
+}
+
+type Subscription struct {
+	Plan               string              `json:"plan"`
+	Status             string              `json:"status"`
+	Active             bool                `json:"active"`
+	CurrentPeriodStart time.Time           `json:"current_period_start"`
+	CurrentPeriodEnd   *time.Time          `json:"current_period_end"`
+	History            []SubscriptionEvent `json:"history"`
+}
+
+type SubscriptionEvent struct {
+	CreatedAt        time.Time  `json:"created_at"`
+	Event            string     `json:"event"`
+	Plan             string     `json:"plan"`
+	Status           string     `json:"status"`
+	CurrentPeriodEnd *time.Time `json:"current_period_end"`
+}
+
+func nullTimePointer(t sql.NullTime) *time.Time {
+	if !t.Valid {
+		return nil
+	}
+	return &t.Time
+}
+
+// handlerGetSubscription shows the caller's membership and how it got there.
+func (apiCfg *apiConfig) handlerGetSubscription(w http.ResponseWriter, r *http.Request) {
+	subject := authenticatedSubject(r)
+	if !requireScope(w, subject, auth.ScopeAccount) {
+		return
+	}
+
+	subscription, err := apiCfg.DB.GetSubscription(r.Context(), subject.UserID)
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, http.StatusNotFound, errSubscriptionNotFound.Error())
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Could not load subscription")
+		return
+	}
+
+	events, err := apiCfg.DB.ListSubscriptionEvents(r.Context(), subject.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Could not load subscription")
+		return
+	}
+
+	history := []SubscriptionEvent{}
+	for _, event := range events {
+		history = append(history, SubscriptionEvent{
+			CreatedAt:        event.CreatedAt,
+			Event:            event.Event,
+			Plan:             event.Plan,
+			Status:           event.Status,
+			CurrentPeriodEnd: nullTimePointer(event.CurrentPeriodEnd),
+		})
+	}
+
+	respondWithJSON(w, http.StatusOK, Subscription{
+		Plan:               subscription.Plan,
+		Status:             subscription.Status,
+		Active:             subscriptionIsActive(subscription, time.Now()),
+		CurrentPeriodStart: subscription.CurrentPeriodStart,
+		CurrentPeriodEnd:   nullTimePointer(subscription.CurrentPeriodEnd),
+		History:            history,
+	})
+}